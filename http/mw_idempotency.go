@@ -0,0 +1,144 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+type MWIdempotencyOption struct {
+	Header   string   //幂等键请求头，默认Idempotency-Key
+	Methods  []string //需要幂等处理的请求方法，默认POST/PUT/PATCH/DELETE
+	Required bool     //是否必须提供幂等键，否则响应400
+}
+
+func (o *MWIdempotencyOption) MustNormalize() *MWIdempotencyOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if _string.Empty(o.Header) {
+		o.Header = `Idempotency-Key`
+	}
+
+	if len(o.Methods) == 0 {
+		o.Methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+
+	for i, m := range o.Methods {
+		o.Methods[i] = strings.ToUpper(m)
+	}
+
+	return o
+}
+
+// 缓存响应内容
+type idempotencyRspWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRspWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRspWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// MWIdempotency 幂等中间件，相同幂等键的重复请求将重放首次请求的响应，首次请求处理中则响应409
+// 仅保存状态码小于500且未调用c.Error()的响应，否则释放幂等键以允许客户端重试
+// 幂等键作用域为请求方法+路由，重放的响应包含响应头Idempotent-Replayed: true
+func MWIdempotency(store *rdb.IdempotencyStore, option *MWIdempotencyOption) gin.HandlerFunc {
+	util.AssertOk(store != nil, `store为空`)
+
+	o := option.MustNormalize()
+	logger := newLogger(`MWIdempotency`)
+
+	methods := make(map[string]bool)
+	for _, m := range o.Methods {
+		methods[m] = true
+	}
+
+	return func(c *gin.Context) {
+		if !methods[c.Request.Method] {
+			c.Next()
+			return
+		}
+
+		idemKey := c.GetHeader(o.Header)
+		if idemKey == `` {
+			if o.Required {
+				httpErr := NewError(http.StatusBadRequest, util.ErrCodeIllegalArg, `缺少请求头`+o.Header)
+				c.AbortWithStatusJSON(httpErr.Status(), httpErr)
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		entry, err := store.Begin(c.Request.Context(), c.Request.Method+` `+c.FullPath()+`:`+idemKey)
+		if err != nil {
+			httpErr := NewError(http.StatusServiceUnavailable, util.ErrCodeRedis, err, `幂等检查出错`)
+			c.AbortWithStatusJSON(httpErr.Status(), httpErr)
+			return
+		}
+
+		switch entry.State {
+		case rdb.IdempotencyStateInProgress:
+			httpErr := NewError(http.StatusConflict, util.ErrCodeAborted, `请求处理中`)
+			c.AbortWithStatusJSON(httpErr.Status(), httpErr)
+			return
+		case rdb.IdempotencyStateCompleted:
+			c.Header(`Idempotent-Replayed`, `true`)
+			c.Data(entry.Record.Status, entry.Record.ContentType, entry.Record.Body)
+			c.Abort()
+			return
+		}
+
+		//客户端可能已断开连接，不使用请求ctx
+		ctx := context.Background()
+		abort := func() {
+			if _, err := store.Abort(ctx, entry); err != nil {
+				logger.Error(`释放幂等键出错`, zap.String(`key`, idemKey), zap.Error(err))
+			}
+		}
+
+		w := &idempotencyRspWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		//处理请求崩溃时释放幂等键后继续抛出，否则重试请求将一直响应409直到幂等键过期
+		defer func() {
+			if r := recover(); r != nil {
+				c.Writer = w.ResponseWriter
+				abort()
+				panic(r)
+			}
+		}()
+
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if len(c.Errors) > 0 || w.Status() >= http.StatusInternalServerError {
+			abort()
+			return
+		}
+
+		record := &rdb.IdempotencyRecord{
+			Status:      w.Status(),
+			ContentType: w.Header().Get(`Content-Type`),
+			Body:        w.body.Bytes(),
+		}
+
+		if _, err = store.Complete(ctx, entry, record); err != nil {
+			logger.Error(`保存幂等响应出错`, zap.String(`key`, idemKey), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package http
+
+import (
+	"fmt"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/conf"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 限流key来源
+const (
+	RateLimitKeyByIP      = `ip`      //客户端IP
+	RateLimitKeyBySession = `session` //session用户标识，如不存在则使用客户端IP
+	RateLimitKeyByRoute   = `route`   //路由，即全部客户端共享限流配额
+)
+
+type MWRateLimitRule struct {
+	Route             string //路由，格式：`GET /users/:id`，省略请求方法则匹配全部请求方法
+	KeyBy             string //限流key来源，为空则使用MWRateLimitOption.KeyBy
+	rdb.LimiterOption `mapstructure:",squash"`
+}
+
+type MWRateLimitOption struct {
+	Disabled   bool               //是否禁用
+	KeyPrefix  string             //限流redis key前缀，默认rl:http:
+	KeyBy      string             //限流key来源：ip/session/route，默认ip
+	SessionKey string             //KeyBy=session时用户标识对应的session键名称
+	Default    *rdb.LimiterOption //未匹配路由的限流配置，为空则不限流
+	Rules      []*MWRateLimitRule //路由限流配置
+}
+
+func (o *MWRateLimitOption) MustNormalize() *MWRateLimitOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if _string.Empty(o.KeyPrefix) {
+		o.KeyPrefix = `rl:http:`
+	}
+
+	if _string.Empty(o.KeyBy) {
+		o.KeyBy = RateLimitKeyByIP
+	}
+
+	for _, rule := range o.Rules {
+		util.AssertOk(rule != nil, `rule为空`)
+		util.AssertNotEmpty(rule.Route, `Route为空`)
+
+		if _string.Empty(rule.KeyBy) {
+			rule.KeyBy = o.KeyBy
+		}
+
+		mustCheckRateLimitKeyBy(rule.KeyBy, o.SessionKey)
+	}
+
+	mustCheckRateLimitKeyBy(o.KeyBy, o.SessionKey)
+	return o
+}
+
+func mustCheckRateLimitKeyBy(keyBy, sessionKey string) {
+	switch keyBy {
+	case RateLimitKeyByIP, RateLimitKeyByRoute:
+	case RateLimitKeyBySession:
+		util.AssertNotEmpty(sessionKey, `SessionKey为空`)
+	default:
+		panic(util.NewAssertFailError(`无效KeyBy[%v]`, keyBy))
+	}
+}
+
+// MWRateLimitKeyFn 回调函数，返回限流key。返回空字符串表示不限流
+type MWRateLimitKeyFn func(c *gin.Context, keyBy string) string
+
+type mwRateLimitEntry struct {
+	keyBy   string
+	limiter *rdb.Limiter
+}
+
+// MWRateLimiter 限流中间件，超过限制将响应429
+// 响应头：X-RateLimit-Limit/X-RateLimit-Remaining/X-RateLimit-Reset(秒)，被拒绝时响应头Retry-After(秒)
+type MWRateLimiter struct {
+	option   *MWRateLimitOption
+	keyFn    MWRateLimitKeyFn
+	fallback *mwRateLimitEntry
+	entries  map[string]*mwRateLimitEntry //key为路由
+}
+
+func NewMWRateLimiter(option *MWRateLimitOption, client redis.UniversalClient) *MWRateLimiter {
+	o := option.MustNormalize()
+	m := &MWRateLimiter{
+		option:  o,
+		entries: make(map[string]*mwRateLimitEntry),
+	}
+
+	if o.Default != nil {
+		m.fallback = &mwRateLimitEntry{
+			keyBy:   o.KeyBy,
+			limiter: rdb.MustNewLimiter(o.KeyPrefix+`default:`, o.Default, client),
+		}
+	}
+
+	for _, rule := range o.Rules {
+		route := normalizeRateLimitRoute(rule.Route)
+		_, exist := m.entries[route]
+		util.AssertOk(!exist, `路由限流配置重复[%v]`, rule.Route)
+
+		option := rule.LimiterOption
+		m.entries[route] = &mwRateLimitEntry{
+			keyBy:   rule.KeyBy,
+			limiter: rdb.MustNewLimiter(o.KeyPrefix+route+`:`, &option, client),
+		}
+	}
+
+	return m
+}
+
+// 统一将路由的请求方法转换为大写，如：get /users => GET /users
+func normalizeRateLimitRoute(route string) string {
+	route = strings.TrimSpace(route)
+	if i := strings.Index(route, ` `); i > 0 {
+		return strings.ToUpper(route[:i]) + ` ` + strings.TrimSpace(route[i+1:])
+	}
+
+	return route
+}
+
+// WithKeyFn 设置自定义限流key回调函数
+func (m *MWRateLimiter) WithKeyFn(fn MWRateLimitKeyFn) *MWRateLimiter {
+	m.keyFn = fn
+	return m
+}
+
+func (m *MWRateLimiter) entry(c *gin.Context) *mwRateLimitEntry {
+	path := c.FullPath()
+	if e, ok := m.entries[c.Request.Method+` `+path]; ok {
+		return e
+	}
+
+	if e, ok := m.entries[path]; ok {
+		return e
+	}
+
+	return m.fallback
+}
+
+func (m *MWRateLimiter) key(c *gin.Context, keyBy string) string {
+	if m.keyFn != nil {
+		return m.keyFn(c, keyBy)
+	}
+
+	switch keyBy {
+	case RateLimitKeyByRoute:
+		return `*`
+	case RateLimitKeyBySession:
+		if v := GetSession(c).Get(m.option.SessionKey); v != nil {
+			return fmt.Sprintf(`u:%v`, v)
+		}
+	}
+
+	return `ip:` + c.ClientIP()
+}
+
+// Handle 处理请求。如果KeyBy=session，则依赖中间件MWSession()
+func (m *MWRateLimiter) Handle(c *gin.Context) {
+	e := m.entry(c)
+	if m.option.Disabled || e == nil {
+		c.Next()
+		return
+	}
+
+	key := m.key(c, e.keyBy)
+	if key == `` {
+		c.Next()
+		return
+	}
+
+	rs, err := e.limiter.Allow(c.Request.Context(), key)
+	if err != nil && !rs.Allowed {
+		httpErr := NewError(http.StatusServiceUnavailable, util.ErrCodeRedis, err, `限流检查出错`)
+		c.AbortWithStatusJSON(httpErr.Status(), httpErr)
+		return
+	}
+
+	if err == nil {
+		setRateLimitHeaders(c, rs)
+	}
+
+	if !rs.Allowed {
+		httpErr := NewError(http.StatusTooManyRequests, util.ErrCodeTooOften, `请求过于频繁`)
+		c.AbortWithStatusJSON(httpErr.Status(), httpErr)
+		return
+	}
+
+	c.Next()
+}
+
+func setRateLimitHeaders(c *gin.Context, rs *rdb.LimitResult) {
+	c.Header(`X-RateLimit-Limit`, strconv.FormatInt(rs.Limit, 10))
+	c.Header(`X-RateLimit-Remaining`, strconv.FormatInt(rs.Remaining, 10))
+	c.Header(`X-RateLimit-Reset`, strconv.FormatInt(ceilSeconds(rs.ResetAfter), 10))
+
+	if !rs.Allowed && rs.RetryAfter > 0 {
+		c.Header(`Retry-After`, strconv.FormatInt(ceilSeconds(rs.RetryAfter), 10))
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// MWRateLimit 限流中间件
+func MWRateLimit(option *MWRateLimitOption, client redis.UniversalClient) gin.HandlerFunc {
+	return NewMWRateLimiter(option, client).Handle
+}
+
+// MustNewMWRateLimitFromCfgFile 读取配置文件创建限流中间件，如：conf/ratelimit_http.toml
+func MustNewMWRateLimitFromCfgFile(file string, client redis.UniversalClient) gin.HandlerFunc {
+	option := &MWRateLimitOption{}
+	conf.MustLoad(option, file)
+	return MWRateLimit(option, client)
+}
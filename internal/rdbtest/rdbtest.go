@@ -0,0 +1,130 @@
+// Package rdbtest 提供内存redis服务(基于miniredis，支持lua脚本)，仅用于本模块离线运行依赖redis的测试
+package rdbtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+)
+
+// 内存redis时钟推进间隔
+const clockTick = 10 * time.Millisecond
+
+// Server 内存redis服务，跟随系统时间推进时钟使key按TTL自动过期，可调用FastForward()快进
+// 后台每10ms推进1次时钟，NewClient()返回的客户端执行命令前也会推进时钟，避免key过期延迟
+type Server struct {
+	*miniredis.Miniredis
+
+	mu       sync.Mutex
+	last     time.Time //上次推进时钟的时间
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func NewServer() (*Server, error) {
+	m, err := miniredis.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Miniredis: m, last: time.Now(), stop: make(chan struct{})}
+	go s.runClock()
+
+	return s, nil
+}
+
+func MustNewServer() *Server {
+	s, err := NewServer()
+	util.AssertNilErr(err, `启动内存redis出错`)
+	return s
+}
+
+func (s *Server) runClock() {
+	ticker := time.NewTicker(clockTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.syncClock()
+		}
+	}
+}
+
+// 推进时钟到当前时间
+func (s *Server) syncClock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.SetTime(now)
+	s.FastForward(now.Sub(s.last))
+	s.last = now
+}
+
+// Option 连接此服务的客户端配置，可用于rdb.MustNewClient()
+func (s *Server) Option(db int) *rdb.Option {
+	return &rdb.Option{
+		Mode:  rdb.ModeStandalone,
+		Addrs: []string{s.Addr()},
+		DB:    db,
+	}
+}
+
+// NewClient 创建连接此服务的客户端
+func (s *Server) NewClient(db int) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: s.Addr(), DB: db})
+	client.AddHook(clockHook{s})
+	return client
+}
+
+// 执行命令前推进时钟
+type clockHook struct {
+	s *Server
+}
+
+func (h clockHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.s.syncClock()
+	return ctx, nil
+}
+
+func (h clockHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h clockHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.s.syncClock()
+	return ctx, nil
+}
+
+func (h clockHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func (s *Server) Close() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.Miniredis.Close()
+	})
+}
+
+// MustNewClient 启动内存redis并返回客户端，测试结束后自动关闭
+func MustNewClient(tb testing.TB) redis.UniversalClient {
+	s := MustNewServer()
+	client := s.NewClient(0)
+
+	tb.Cleanup(func() {
+		_ = client.Close()
+		s.Close()
+	})
+
+	return client
+}
@@ -1,6 +1,7 @@
 package orm
 
 import (
+	"fmt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -93,3 +94,11 @@ func ScopeOmit(columns ...string) Scope {
 		return db.Omit(columns...)
 	}
 }
+
+// ScopeFencingToken 仅匹配防护令牌列不大于token的记录，用于拒绝旧令牌的写操作，见 UpdateFenced()
+// 防护令牌列为NULL视为0，未写入过防护令牌的记录可被任意令牌更新
+func ScopeFencingToken(column string, token int64) Scope {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(fmt.Sprintf(`COALESCE(%s, 0) <= ?`, column), token)
+	}
+}
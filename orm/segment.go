@@ -0,0 +1,60 @@
+package orm
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/util"
+	"gorm.io/gorm"
+	"time"
+)
+
+// SegmentRecord 号段最大值记录
+type SegmentRecord struct {
+	Name      string `gorm:"primaryKey;size:64"`
+	MaxID     int64
+	UpdatedAt time.Time
+}
+
+// SegmentStore 号段最大值数据库存储，实现接口rdb.SegmentStore
+type SegmentStore struct {
+	db    *gorm.DB
+	table string
+}
+
+// NewSegmentStore 参数table为表名，为空则默认id_segment。可调用AutoMigrate()创建表
+func NewSegmentStore(db *gorm.DB, table string) *SegmentStore {
+	util.AssertOk(db != nil, `db为空`)
+
+	if _string.Empty(table) {
+		table = `id_segment`
+	}
+
+	return &SegmentStore{db: db, table: table}
+}
+
+func (s *SegmentStore) AutoMigrate() error {
+	return s.db.Table(s.table).AutoMigrate(&SegmentRecord{})
+}
+
+func (s *SegmentStore) Load(ctx context.Context, name string) (int64, error) {
+	rec := &SegmentRecord{}
+	err := s.db.WithContext(ctx).Table(s.table).Where(`name=?`, name).Take(rec).Error
+	if IsRecordNotFoundErr(err) {
+		return 0, nil
+	}
+
+	return rec.MaxID, err
+}
+
+// Save 仅当maxID大于已保存的值时更新
+func (s *SegmentStore) Save(ctx context.Context, name string, maxID int64) error {
+	//Table()返回的db会共享Statement，更新和插入需分别创建
+	rs := s.db.WithContext(ctx).Table(s.table).Where(`name=? AND max_id<?`, name, maxID).
+		Updates(map[string]interface{}{`max_id`: maxID, `updated_at`: time.Now()})
+	if rs.Error != nil || rs.RowsAffected > 0 {
+		return rs.Error
+	}
+
+	return s.db.WithContext(ctx).Table(s.table).Clauses(ExprOnConflictDoNothing(`name`)).
+		Create(&SegmentRecord{Name: name, MaxID: maxID, UpdatedAt: time.Now()}).Error
+}
@@ -151,3 +151,20 @@ func BatchUpdate(db *gorm.DB, records interface{}, scopes ...IScope) (affected i
 
 	return affected, nil
 }
+
+// UpdateFenced 使用防护令牌更新记录，同时更新防护令牌列为token。返回false表示令牌已过期(或记录不存在)
+// 参数db应已设置查询条件，如：db.Model(&User{}).Where(`id=?`,1)
+func UpdateFenced(db *gorm.DB, column string, token int64, values map[string]interface{}) (bool, error) {
+	updates := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		updates[k] = v
+	}
+	updates[column] = token
+
+	rs := db.Scopes(ScopeFencingToken(column, token)).Updates(updates)
+	if rs.Error != nil {
+		return false, rs.Error
+	}
+
+	return rs.RowsAffected > 0, nil
+}
@@ -0,0 +1,178 @@
+package rdb
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"hash/fnv"
+	"math"
+)
+
+// redis bitmap最大长度为512MB
+const bloomFilterMaxBits = uint64(1) << 32
+
+// BloomFilter 布隆过滤器，使用redis bitmap保存。MayContain()返回false表示元素一定不存在，返回true表示元素可能存在
+type BloomFilter struct {
+	key    string
+	bits   uint64 //bitmap长度
+	hashes uint64 //哈希函数数量
+	client redis.UniversalClient
+}
+
+// MustNewBloomFilter 根据预期元素数量和误判率计算bitmap长度和哈希函数数量
+func MustNewBloomFilter(key string, expectedItems uint64, falsePositiveRate float64, client redis.UniversalClient) *BloomFilter {
+	util.AssertNotEmpty(key, `key为空`)
+	util.AssertOk(expectedItems > 0, `expectedItems<=0`)
+	util.AssertOk(falsePositiveRate > 0 && falsePositiveRate < 1, `falsePositiveRate应在(0,1)之间`)
+	util.AssertOk(client != nil, `client为空`)
+
+	n := float64(expectedItems)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	util.AssertOk(m <= float64(bloomFilterMaxBits), `bitmap长度超过限制，请减少expectedItems或增大falsePositiveRate`)
+
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	return &BloomFilter{
+		key:    key,
+		bits:   uint64(m),
+		hashes: uint64(k),
+		client: client,
+	}
+}
+
+func (b *BloomFilter) Key() string {
+	return b.key
+}
+
+// Bits bitmap长度
+func (b *BloomFilter) Bits() uint64 {
+	return b.bits
+}
+
+// Hashes 哈希函数数量
+func (b *BloomFilter) Hashes() uint64 {
+	return b.hashes
+}
+
+// 使用双重哈希计算k个bit位置：h1+i*h2
+func (b *BloomFilter) offsets(item string) []int64 {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(item))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:])
+
+	offsets := make([]int64, b.hashes)
+	for i := uint64(0); i < b.hashes; i++ {
+		offsets[i] = int64((h1 + i*h2) % b.bits)
+	}
+
+	return offsets
+}
+
+// Add 添加元素
+func (b *BloomFilter) Add(ctx context.Context, items ...string) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	_, err := b.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, item := range items {
+			for _, offset := range b.offsets(item) {
+				p.SetBit(ctx, b.key, offset, 1)
+			}
+		}
+		return nil
+	})
+
+	return err
+}
+
+// MayContain 元素是否可能存在，返回结果与参数items顺序一致
+func (b *BloomFilter) MayContain(ctx context.Context, items ...string) ([]bool, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([][]*redis.IntCmd, len(items))
+	_, err := b.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, item := range items {
+			for _, offset := range b.offsets(item) {
+				cmds[i] = append(cmds[i], p.GetBit(ctx, b.key, offset))
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]bool, len(items))
+	for i := range items {
+		rs[i] = true
+		for _, cmd := range cmds[i] {
+			if cmd.Val() == 0 {
+				rs[i] = false
+				break
+			}
+		}
+	}
+
+	return rs, nil
+}
+
+// Reset 清空布隆过滤器
+func (b *BloomFilter) Reset(ctx context.Context) error {
+	return b.client.Del(ctx, b.key).Err()
+}
+
+// HyperLogLog 基数统计，如：UV统计。标准误差约0.81%
+type HyperLogLog struct {
+	key    string
+	client redis.UniversalClient
+}
+
+func NewHyperLogLog(key string, client redis.UniversalClient) *HyperLogLog {
+	util.AssertNotEmpty(key, `key为空`)
+	util.AssertOk(client != nil, `client为空`)
+	return &HyperLogLog{key: key, client: client}
+}
+
+func (h *HyperLogLog) Key() string {
+	return h.key
+}
+
+// Add 添加元素，返回true表示基数估计值已改变
+func (h *HyperLogLog) Add(ctx context.Context, items ...string) (bool, error) {
+	if len(items) == 0 {
+		return false, nil
+	}
+
+	args := make([]interface{}, len(items))
+	for i, item := range items {
+		args[i] = item
+	}
+
+	n, err := h.client.PFAdd(ctx, h.key, args...).Result()
+	return n == 1, err
+}
+
+// Count 基数估计值
+func (h *HyperLogLog) Count(ctx context.Context) (int64, error) {
+	return h.client.PFCount(ctx, h.key).Result()
+}
+
+// CountUnion 与其他key合并后的基数估计值，不修改数据。redis集群下key应位于同1个slot
+func (h *HyperLogLog) CountUnion(ctx context.Context, otherKeys ...string) (int64, error) {
+	return h.client.PFCount(ctx, append([]string{h.key}, otherKeys...)...).Result()
+}
+
+// Merge 合并其他key到当前key，如：合并每日UV到每周UV。redis集群下key应位于同1个slot
+func (h *HyperLogLog) Merge(ctx context.Context, otherKeys ...string) error {
+	return h.client.PFMerge(ctx, h.key, otherKeys...).Err()
+}
+
+func (h *HyperLogLog) Reset(ctx context.Context) error {
+	return h.client.Del(ctx, h.key).Err()
+}
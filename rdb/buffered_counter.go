@@ -0,0 +1,310 @@
+package rdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+type BufferedCounterOption struct {
+	Ticker          async.TickerOption //刷新触发条件，MaxCount为累计增加次数。默认每1s或累计1000次刷新
+	TTL             time.Duration      //刷新后设置key的TTL，小于等于0则不设置
+	FlushTimeout    time.Duration      //每次刷新超时时长，默认5s
+	CloseRetryCount int                //关闭时刷新失败重试次数，默认3
+}
+
+func (o *BufferedCounterOption) MustNormalize() *BufferedCounterOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if o.Ticker.Period <= 0 && o.Ticker.MinCount <= 0 && o.Ticker.MaxCount <= 0 {
+		o.Ticker = async.NewTickerOption(0, 1000, time.Second)
+	}
+
+	o.Ticker = o.Ticker.MustNormalize()
+
+	if o.FlushTimeout <= 0 {
+		o.FlushTimeout = 5 * time.Second
+	}
+
+	if o.CloseRetryCount <= 0 {
+		o.CloseRetryCount = 3
+	}
+
+	return o
+}
+
+// CounterDelta 待刷新的计数增量，Field为空表示INCRBY，否则为HINCRBY
+type CounterDelta struct {
+	Key   string
+	Field string
+	Delta int64
+}
+
+type counterKey struct {
+	key, field string
+}
+
+// BufferedCounter 缓冲计数器，在内存里按key合并计数增量，定时或累计一定次数后使用pipeline批量刷新到redis
+// 因连接等原因刷新失败的增量将合并回缓冲区并在下次刷新时重试，关闭时刷新失败将调用OnFlushFailed()，可自行持久化
+// 命令本身出错(如：key类型错误WRONGTYPE)的增量重试也不会成功，将立即调用OnFlushFailed()并丢弃
+// 注意：redis已执行但响应丢失的命令会被重试，计数可能偏大
+type BufferedCounter struct {
+	option *BufferedCounterOption
+	client redis.UniversalClient
+	logger *zap.Logger
+	ticker *async.Ticker
+
+	mu      sync.Mutex
+	deltas  map[counterKey]int64
+	closed  bool
+	flushMu sync.Mutex //串行刷新
+
+	onFlushFailed func(deltas []*CounterDelta, err error)
+	done          chan struct{}
+}
+
+func MustNewBufferedCounter(option *BufferedCounterOption, client redis.UniversalClient) *BufferedCounter {
+	util.AssertOk(client != nil, `client为空`)
+
+	c := &BufferedCounter{
+		option: option.MustNormalize(),
+		client: client,
+		logger: newLogger(`buffered_counter`),
+		deltas: make(map[counterKey]int64),
+		done:   make(chan struct{}),
+	}
+
+	c.ticker = async.NewTicker(c.option.Ticker)
+	go c.run()
+
+	return c
+}
+
+// WithOnFlushFailed 设置刷新失败回调，参数deltas为未能刷新且不再重试的增量。默认输出错误日志
+// 关闭时刷新失败或命令本身出错时调用
+func (c *BufferedCounter) WithOnFlushFailed(fn func(deltas []*CounterDelta, err error)) *BufferedCounter {
+	c.onFlushFailed = fn
+	return c
+}
+
+func (c *BufferedCounter) run() {
+	defer close(c.done)
+
+	for range c.ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), c.option.FlushTimeout)
+		if err := c.Flush(ctx); err != nil {
+			c.logger.Warn(`刷新计数出错，下次刷新时重试`, zap.Int(`pending`, c.Pending()), zap.Error(err))
+		}
+		cancel()
+	}
+}
+
+// Incr 增加key的计数，对应INCRBY
+func (c *BufferedCounter) Incr(key string, delta int64) {
+	c.add(counterKey{key: key}, delta)
+}
+
+// HIncr 增加hash字段的计数，对应HINCRBY
+func (c *BufferedCounter) HIncr(key, field string, delta int64) {
+	util.AssertNotEmpty(field, `field为空`)
+	c.add(counterKey{key: key, field: field}, delta)
+}
+
+func (c *BufferedCounter) add(k counterKey, delta int64) {
+	util.AssertNotEmpty(k.key, `key为空`)
+	if delta == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.logger.Warn(`计数器已关闭，忽略增量`, zap.String(`key`, k.key), zap.String(`field`, k.field), zap.Int64(`delta`, delta))
+		return
+	}
+
+	c.deltas[k] += delta
+	c.mu.Unlock()
+
+	c.ticker.IncrCount(1)
+}
+
+// Pending 待刷新的key数量
+func (c *BufferedCounter) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.deltas)
+}
+
+// Get 查询key的计数，包括未刷新的增量
+func (c *BufferedCounter) Get(ctx context.Context, key string) (int64, error) {
+	v, err := c.client.Get(ctx, key).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	return v + c.pending(counterKey{key: key}), nil
+}
+
+// HGet 查询hash字段的计数，包括未刷新的增量
+func (c *BufferedCounter) HGet(ctx context.Context, key, field string) (int64, error) {
+	v, err := c.client.HGet(ctx, key, field).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	return v + c.pending(counterKey{key: key, field: field}), nil
+}
+
+func (c *BufferedCounter) pending(k counterKey) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.deltas[k]
+}
+
+// Flush 立即刷新全部增量，可重试的失败增量将合并回缓冲区，不可重试的失败增量将调用OnFlushFailed()
+func (c *BufferedCounter) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	c.mu.Lock()
+	deltas := c.deltas
+	c.deltas = make(map[counterKey]int64)
+	c.mu.Unlock()
+
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	failed, err := c.doFlush(ctx, deltas)
+	if len(failed) > 0 {
+		c.mu.Lock()
+		for k, v := range failed {
+			c.deltas[k] += v
+		}
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+// 返回执行失败且可重试的增量，不可重试的增量调用OnFlushFailed()
+func (c *BufferedCounter) doFlush(ctx context.Context, deltas map[counterKey]int64) (map[counterKey]int64, error) {
+	keys := make([]counterKey, 0, len(deltas))
+	cmds := make([]*redis.IntCmd, 0, len(deltas))
+	expires := make(map[string]bool)
+
+	_, err := c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for k, v := range deltas {
+			keys = append(keys, k)
+			if k.field == `` {
+				cmds = append(cmds, p.IncrBy(ctx, k.key, v))
+			} else {
+				cmds = append(cmds, p.HIncrBy(ctx, k.key, k.field, v))
+			}
+
+			if c.option.TTL > 0 && !expires[k.key] {
+				expires[k.key] = true
+				p.Expire(ctx, k.key, c.option.TTL)
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		return nil, nil
+	}
+
+	failed := make(map[counterKey]int64)
+	for i, cmd := range cmds {
+		k, e := keys[i], cmd.Err()
+		if e == nil {
+			continue
+		}
+
+		if isRetryableCmdErr(e) {
+			failed[k] = deltas[k]
+		} else {
+			c.drop([]*CounterDelta{{Key: k.key, Field: k.field, Delta: deltas[k]}}, e)
+		}
+	}
+
+	return failed, err
+}
+
+// 命令是否可重试，redis返回的错误除集群/主从切换等临时错误外均不可重试，其他错误(如：连接错误)可重试
+func isRetryableCmdErr(err error) bool {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return true
+	}
+
+	msg := rerr.Error()
+	for _, prefix := range []string{`LOADING `, `READONLY `, `CLUSTERDOWN `, `TRYAGAIN `, `MASTERDOWN `, `BUSY `} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 丢弃未能刷新的增量
+func (c *BufferedCounter) drop(deltas []*CounterDelta, err error) {
+	if c.onFlushFailed != nil {
+		c.onFlushFailed(deltas, err)
+		return
+	}
+
+	for _, d := range deltas {
+		c.logger.Error(`刷新计数失败，增量已丢弃`,
+			zap.String(`key`, d.Key), zap.String(`field`, d.Field), zap.Int64(`delta`, d.Delta), zap.Error(err))
+	}
+}
+
+// Close 停止定时刷新并刷新剩余增量，失败将重试。关闭后调用Incr()将忽略增量
+func (c *BufferedCounter) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	c.mu.Unlock()
+
+	c.ticker.Close()
+	<-c.done
+
+	counter := util.NewStepRetryCounter(c.option.CloseRetryCount, 100*time.Millisecond, 100*time.Millisecond, time.Second)
+	err := util.DoRetry(counter, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), c.option.FlushTimeout)
+		defer cancel()
+
+		return c.Flush(ctx)
+	})
+
+	if err == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	deltas := make([]*CounterDelta, 0, len(c.deltas))
+	for k, v := range c.deltas {
+		deltas = append(deltas, &CounterDelta{Key: k.key, Field: k.field, Delta: v})
+	}
+	c.deltas = make(map[counterKey]int64)
+	c.mu.Unlock()
+
+	c.drop(deltas, err)
+	return err
+}
@@ -2,7 +2,11 @@ package rdb
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
 )
 
 // 以下脚本，如果key不存在，则取零值，可匹配0，false,“ 注：redis将lua的nil转换为false
@@ -13,13 +17,22 @@ var (
 
 	setNEQScript  = redis.NewScript(`local v=redis.call("get", KEYS[1]) or '';if v ~= ARGV[1] then redis.call("set", KEYS[1], ARGV[2]);return 1 else return 0 end`)
 	delNEQScript  = redis.NewScript(`local v=redis.call("get", KEYS[1]) or '';if v ~= ARGV[1] then redis.call("del", KEYS[1]);return 1 else return 0 end`)
-	incrNEQScript = redis.NewScript(`local v=redis.call("get", KEYS[1]) or '';if v ~= ARGV[1] then redis.call("incrby", KEYS[1], ARGV[2]) end;return v`)
+	incrNEQScript = redis.NewScript(`local v=redis.call("get", KEYS[1]) or '';if v ~= ARGV[1] then return {1, v, redis.call("incrby", KEYS[1], ARGV[2])} end;return {0, v, v}`)
 
 	hsetEQScript  = redis.NewScript(`local v=redis.call("hget", KEYS[1], KEYS[2]) or '';if v == ARGV[1] then redis.call("hset", KEYS[1], KEYS[2], ARGV[2]);return 1 else return 0 end`)
 	hdelEQScript  = redis.NewScript(`local v=redis.call("hget", KEYS[1], KEYS[2]) or '';if v == ARGV[1] then redis.call("hdel", KEYS[1], KEYS[2]);return 1 else return 0 end`)
 	hincrEQScript = redis.NewScript(`local v=redis.call("hget", KEYS[1], KEYS[2]) or '';if v == ARGV[1] then redis.call("hincrby", KEYS[1], KEYS[2], ARGV[2]);return 1 else return 0 end`)
+
+	//防护令牌不小于已保存的令牌则写入，KEYS：tokenKey,key。ARGV：token,val
+	setFencedScript = redis.NewScript(`local v=tonumber(redis.call("get", KEYS[1]) or '0');if tonumber(ARGV[1]) >= v then redis.call("set", KEYS[1], ARGV[1]);redis.call("set", KEYS[2], ARGV[2]);return 1 else return 0 end`)
+
+	//防护令牌保存在hash字段_fence。KEYS：key,field。ARGV：token,val
+	hsetFencedScript = redis.NewScript(`local v=tonumber(redis.call("hget", KEYS[1], "_fence") or '0');if tonumber(ARGV[1]) >= v then redis.call("hset", KEYS[1], "_fence", ARGV[1], KEYS[2], ARGV[2]);return 1 else return 0 end`)
 )
 
+// FencingTokenField HSetFenced()保存防护令牌的hash字段名称
+const FencingTokenField = `_fence`
+
 // 如果值相等则设置
 func SetEQ(ctx context.Context, client redis.Scripter, key string, expect interface{}, val interface{}) (bool, error) {
 	return setEQScript.Run(ctx, client, []string{key}, expect, val).Bool()
@@ -50,15 +63,14 @@ func DelNEQ(ctx context.Context, client redis.Scripter, key string, expect inter
 	return delNEQScript.Run(ctx, client, []string{key}, expect).Bool()
 }
 
-// 如果值不相等则新增，返回当前值
-// 注意：如果val==0，则会造成返回bool误判
-func IncrNEQ(ctx context.Context, client redis.Scripter, key string, expect, val int64) (bool, int64, error) {
-	v, err := incrNEQScript.Run(ctx, client, []string{key}, expect, val).Int64() //v为更新前的值
-	if err != nil || v == expect {
-		return false, v, err
+// 如果值不相等则新增，返回新增前后的值。key不存在则原值为0
+func IncrNEQ(ctx context.Context, client redis.Scripter, key string, expect, val int64) (*IncrResult, error) {
+	rs, err := incrNEQScript.Run(ctx, client, []string{key}, expect, val).Slice()
+	if err != nil {
+		return nil, err
 	}
 
-	return true, v + val, nil
+	return newIncrResult(rs)
 }
 
 // 如果值相等则设置
@@ -80,3 +92,275 @@ func HIncrEQ(ctx context.Context, client redis.Scripter, key, field string, expe
 
 	return true, expect + val, nil
 }
+
+// 如果防护令牌不小于tokenKey保存的令牌则设置key，同时保存令牌到tokenKey。旧令牌将被拒绝
+// 令牌来自 SessionLock.FencingToken()，redis集群下tokenKey与key应在同一个slot
+func SetFenced(ctx context.Context, client redis.Scripter, tokenKey, key string, token int64, val interface{}) (bool, error) {
+	return setFencedScript.Run(ctx, client, []string{tokenKey, key}, token, val).Bool()
+}
+
+// 如果防护令牌不小于hash字段_fence保存的令牌则设置字段，同时保存令牌。旧令牌将被拒绝
+func HSetFenced(ctx context.Context, client redis.Scripter, key, field string, token int64, val interface{}) (bool, error) {
+	return hsetFencedScript.Run(ctx, client, []string{key, field}, token, val).Bool()
+}
+
+// 设置值并处理TTL，参数ttl：-1保留原TTL，0不设置TTL，大于0设置TTL(ms)
+const casSetFn = `
+local function set(k, v, ttl)
+	if ttl == -1 then
+		local pttl = redis.call('pttl', k)
+		redis.call('set', k, v)
+		if pttl > 0 then redis.call('pexpire', k, pttl) end
+	elseif ttl > 0 then
+		redis.call('set', k, v, 'PX', ttl)
+	else
+		redis.call('set', k, v)
+	end
+end
+`
+
+var (
+	setEQTTLScript  = redis.NewScript(casSetFn + `local v=redis.call("get", KEYS[1]) or '';if v == ARGV[1] then set(KEYS[1], ARGV[2], tonumber(ARGV[3]));return 1 else return 0 end`)
+	setNEQTTLScript = redis.NewScript(casSetFn + `local v=redis.call("get", KEYS[1]) or '';if v ~= ARGV[1] then set(KEYS[1], ARGV[2], tonumber(ARGV[3]));return 1 else return 0 end`)
+
+	//KEYS：key...，ARGV：ttl,expect1,val1,expect2,val2...
+	msetEQScript = redis.NewScript(casSetFn + `
+	local ttl = tonumber(ARGV[1])
+	for i, k in ipairs(KEYS) do
+		if (redis.call('get', k) or '') ~= ARGV[i*2] then return 0 end
+	end
+	for i, k in ipairs(KEYS) do
+		set(k, ARGV[i*2+1], ttl)
+	end
+	return 1
+	`)
+
+	//KEYS：key，ARGV：ttl,field1,expect1,val1,field2,expect2,val2...
+	hmsetEQScript = redis.NewScript(`
+	for i = 2, #ARGV, 3 do
+		if (redis.call('hget', KEYS[1], ARGV[i]) or '') ~= ARGV[i+1] then return 0 end
+	end
+	for i = 2, #ARGV, 3 do
+		redis.call('hset', KEYS[1], ARGV[i], ARGV[i+2])
+	end
+	if tonumber(ARGV[1]) > 0 then redis.call('pexpire', KEYS[1], ARGV[1]) end
+	return 1
+	`)
+
+	//KEYS：key，ARGV：path,expect(json),val(json)。保留原TTL
+	//直接修改原始JSON文本里的目标字段，不对整个文档decode/encode，避免cjson丢失大整数精度或将[]转换为{}
+	setJSONFieldEQScript = redis.NewScript(`
+	local s = redis.call('get', KEYS[1]) or '{}'
+	local function ws(i)
+		local _, e = string.find(s, '^[ \t\r\n]*', i)
+		return e + 1
+	end
+	local function skipString(i)
+		local j = i + 1
+		while true do
+			local c = string.sub(s, j, j)
+			if c == '' then error('invalid json') end
+			if c == '\\' then j = j + 2
+			elseif c == '"' then return j + 1
+			else j = j + 1 end
+		end
+	end
+	-- 返回i处JSON值的结束位置(不包含)
+	local function skipValue(i)
+		local c = string.sub(s, i, i)
+		if c == '"' then return skipString(i) end
+		if c == '{' or c == '[' then
+			local depth, j = 0, i
+			while true do
+				local d = string.sub(s, j, j)
+				if d == '' then error('invalid json') end
+				if d == '"' then
+					j = skipString(j)
+				else
+					if d == '{' or d == '[' then depth = depth + 1
+					elseif d == '}' or d == ']' then depth = depth - 1 end
+					j = j + 1
+					if depth == 0 then return j end
+				end
+			end
+		end
+		local _, e = string.find(s, '^[^,}%]%s]+', i)
+		if not e then error('invalid json') end
+		return e + 1
+	end
+	-- 在i处的对象里查找字段，找到返回值起止位置，否则返回nil,右括号位置,是否空对象
+	local function find(i, key)
+		local j = ws(i + 1)
+		if string.sub(s, j, j) == '}' then return nil, j, true end
+		while true do
+			local ke = skipString(j)
+			local k = cjson.decode(string.sub(s, j, ke - 1))
+			j = ws(ws(ke) + 1)
+			local ve = skipValue(j)
+			if k == key then return j, ve end
+			j = ws(ve)
+			if string.sub(s, j, j) == '}' then return nil, j, false end
+			j = ws(j + 1)
+		end
+	end
+
+	local parts = {}
+	for p in string.gmatch(ARGV[1], '[^%.]+') do table.insert(parts, p) end
+	-- 从第j个字段开始构造嵌套对象，j大于字段数则为val
+	local function nested(j)
+		local v = ARGV[3]
+		for x = #parts, j, -1 do v = '{' .. cjson.encode(parts[x]) .. ':' .. v .. '}' end
+		return v
+	end
+
+	local obj = ws(1)
+	if string.sub(s, obj, obj) ~= '{' then return redis.error_reply('value is not a json object') end
+
+	local cur, vs, ve, at, k, sep
+	for i = 1, #parts do
+		local a, b, empty = find(obj, parts[i])
+		if not a then
+			at, k, sep = b, i, (empty and '' or ',')
+			break
+		end
+		if i == #parts then
+			vs, ve, cur = a, b, string.sub(s, a, b - 1)
+		elseif string.sub(s, a, a) ~= '{' then
+			vs, ve, k = a, b, i + 1
+			break
+		else
+			obj = a
+		end
+	end
+
+	local curVal = cjson.null
+	if cur then curVal = cjson.decode(cur) end
+	if curVal ~= cjson.decode(ARGV[2]) then return 0 end
+
+	local out
+	if at then
+		out = string.sub(s, 1, at - 1) .. sep .. cjson.encode(parts[k]) .. ':' .. nested(k + 1) .. string.sub(s, at)
+	elseif cur then
+		out = string.sub(s, 1, vs - 1) .. ARGV[3] .. string.sub(s, ve)
+	else
+		out = string.sub(s, 1, vs - 1) .. nested(k) .. string.sub(s, ve)
+	end
+
+	local pttl = redis.call('pttl', KEYS[1])
+	redis.call('set', KEYS[1], out)
+	if pttl > 0 then redis.call('pexpire', KEYS[1], pttl) end
+	return 1
+	`)
+)
+
+// IncrResult 条件新增结果
+type IncrResult struct {
+	Applied bool  //是否已新增
+	Old     int64 //新增前的值
+	New     int64 //新增后的值，未新增则与Old相同
+}
+
+func newIncrResult(rs []interface{}) (*IncrResult, error) {
+	if len(rs) != 3 {
+		return nil, fmt.Errorf(`无效脚本执行结果[%v]`, rs)
+	}
+
+	applied, _ := rs[0].(int64)
+	r := &IncrResult{Applied: applied == 1}
+	if v, _ := rs[1].(string); v != `` {
+		old, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		r.Old = old
+	}
+
+	r.New, _ = rs[2].(int64)
+	if !r.Applied {
+		r.New = r.Old
+	}
+
+	return r, nil
+}
+
+// CASItem 批量条件设置项
+type CASItem struct {
+	Key    string
+	Expect interface{}
+	Val    interface{}
+}
+
+// HCASItem hash字段批量条件设置项
+type HCASItem struct {
+	Field  string
+	Expect interface{}
+	Val    interface{}
+}
+
+// 转换ttl参数，redis.KeepTTL表示保留原TTL
+func casTTLArg(ttl time.Duration) int64 {
+	if ttl == redis.KeepTTL {
+		return -1
+	}
+
+	return ttl.Milliseconds()
+}
+
+// 如果值相等则设置，参数ttl：redis.KeepTTL保留原TTL，0不设置TTL(与SetEQ()相同)
+func SetEQWithTTL(ctx context.Context, client redis.Scripter, key string, expect, val interface{}, ttl time.Duration) (bool, error) {
+	return setEQTTLScript.Run(ctx, client, []string{key}, expect, val, casTTLArg(ttl)).Bool()
+}
+
+// 如果值不相等则设置，参数ttl：redis.KeepTTL保留原TTL，0不设置TTL(与SetNEQ()相同)
+func SetNEQWithTTL(ctx context.Context, client redis.Scripter, key string, expect, val interface{}, ttl time.Duration) (bool, error) {
+	return setNEQTTLScript.Run(ctx, client, []string{key}, expect, val, casTTLArg(ttl)).Bool()
+}
+
+// 如果全部key的值都相等则全部设置，否则全部不设置。参数ttl同SetEQWithTTL()，redis集群下key应位于同1个slot
+func MSetEQ(ctx context.Context, client redis.Scripter, ttl time.Duration, items ...*CASItem) (bool, error) {
+	if len(items) == 0 {
+		return false, nil
+	}
+
+	keys := make([]string, 0, len(items))
+	args := make([]interface{}, 0, len(items)*2+1)
+	args = append(args, casTTLArg(ttl))
+	for _, item := range items {
+		keys = append(keys, item.Key)
+		args = append(args, item.Expect, item.Val)
+	}
+
+	return msetEQScript.Run(ctx, client, keys, args...).Bool()
+}
+
+// 如果全部字段的值都相等则全部设置，否则全部不设置。如果ttl>0则同时设置key的TTL，否则保留原TTL
+func HMSetEQ(ctx context.Context, client redis.Scripter, key string, ttl time.Duration, items ...*HCASItem) (bool, error) {
+	if len(items) == 0 {
+		return false, nil
+	}
+
+	args := make([]interface{}, 0, len(items)*3+1)
+	args = append(args, ttl.Milliseconds())
+	for _, item := range items {
+		args = append(args, item.Field, item.Expect, item.Val)
+	}
+
+	return hmsetEQScript.Run(ctx, client, []string{key}, args...).Bool()
+}
+
+// 如果JSON字符串值的字段值相等则设置字段值，保留原TTL。key不存在则视为空对象
+// 参数path为点号分隔的对象字段路径，如：user.name，不支持数组下标。参数expect仅支持nil/bool/数字/字符串，nil匹配不存在的字段
+// 仅替换原始JSON文本里的目标字段值，其他字段保持原样。路径上的字段不存在则创建，不是对象则替换为对象
+func SetJSONFieldEQ(ctx context.Context, client redis.Scripter, key, path string, expect, val interface{}) (bool, error) {
+	expectJSON, err := json.Marshal(expect)
+	if err != nil {
+		return false, err
+	}
+
+	valJSON, err := json.Marshal(val)
+	if err != nil {
+		return false, err
+	}
+
+	return setJSONFieldEQScript.Run(ctx, client, []string{key}, path, expectJSON, valJSON).Bool()
+}
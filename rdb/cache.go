@@ -120,6 +120,7 @@ type Cache struct {
 	option *CacheOption
 	client redis.UniversalClient
 	group  *async.CacheGroup
+	filter *BloomFilter                                          //缓存key布隆过滤器，防止查询不存在的key导致缓存穿透
 	onLoad func(ctx context.Context, key string) (string, error) //查询最新缓存值回调函数
 }
 
@@ -131,8 +132,33 @@ func NewCache(option *CacheOption, client redis.UniversalClient, onLoad func(ctx
 	}
 }
 
+// WithBloomFilter 设置布隆过滤器，Fetch()/FetchNew()将直接返回空值而不查询不在过滤器里的key
+// 过滤器需包含全部存在的key，新增数据时应调用filter.Add()
+func (c *Cache) WithBloomFilter(filter *BloomFilter) *Cache {
+	c.filter = filter
+	return c
+}
+
+// 如果设置了布隆过滤器，返回false表示key一定不存在
+func (c *Cache) mayExist(ctx context.Context, key string) (bool, error) {
+	if c.filter == nil {
+		return true, nil
+	}
+
+	rs, err := c.filter.MayContain(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	return rs[0], nil
+}
+
 // Fetch 获取缓存值(最终一致性)。如果缓存值已失效，则返回旧值并异步查询最新值
 func (c *Cache) Fetch(ctx context.Context, key string, cacheTTL time.Duration) (string, error) {
+	if ok, err := c.mayExist(ctx, key); !ok {
+		return ``, err
+	}
+
 	return c.fetchInCacheGroup(key, func() (string, error) {
 		owner := shortuuid.New()
 		val, lock, err := c.runScriptGet(ctx, key, owner)
@@ -165,6 +191,10 @@ func (c *Cache) Fetch(ctx context.Context, key string, cacheTTL time.Duration) (
 
 // FetchNew 获取缓存值(强一致性)。如果缓存值已失效，则同步查询并返回最新值
 func (c *Cache) FetchNew(ctx context.Context, key string, cacheTTL time.Duration) (string, error) {
+	if ok, err := c.mayExist(ctx, key); !ok {
+		return ``, err
+	}
+
 	return c.fetchInCacheGroup(key, func() (string, error) {
 		owner := shortuuid.New()
 		val, lock, err := c.runScriptGet(ctx, key, owner)
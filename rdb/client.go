@@ -0,0 +1,153 @@
+package rdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/bingooh/b-go-util/conf"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+var defaultClient redis.UniversalClient //需自行初始化
+
+func ResetDefaultClient(client redis.UniversalClient) {
+	util.AssertOk(client != nil, `client为空`)
+	newLogger(`client`).Info(`设置全局默认redis客户端`)
+	defaultClient = client
+}
+
+func CloseDefaultClient() error {
+	if defaultClient == nil {
+		return nil
+	}
+
+	return defaultClient.Close()
+}
+
+func MustGetDefaultClient() redis.UniversalClient {
+	util.AssertOk(defaultClient != nil, `defaultClient为空`)
+	return defaultClient
+}
+
+func MustInitDefaultClient() redis.UniversalClient {
+	ResetDefaultClient(MustNewDefaultClient())
+	return defaultClient
+}
+
+// MustNewClient 根据配置创建单机/哨兵/集群客户端，创建后执行PING检查连接
+func MustNewClient(option *Option) redis.UniversalClient {
+	option.MustNormalize()
+
+	uo := option.UniversalOptions()
+	var client redis.UniversalClient
+	switch option.Mode {
+	case ModeSentinel:
+		client = redis.NewFailoverClient(uo.Failover())
+	case ModeCluster:
+		client = redis.NewClusterClient(uo.Cluster())
+	default:
+		client = redis.NewClient(uo.Simple())
+	}
+
+	client.AddHook(newLogHook(option.Log))
+
+	timeout := option.DialTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		util.AssertNilErr(err, `连接redis出错`)
+	}
+
+	return client
+}
+
+func MustNewClientFromCfgFile(fileName string) redis.UniversalClient {
+	option := &Option{}
+	conf.MustLoad(option, fileName)
+	return MustNewClient(option)
+}
+
+// MustNewDefaultClient 读取默认配置文件redis.toml创建redis客户端
+func MustNewDefaultClient() redis.UniversalClient {
+	return MustNewClientFromCfgFile(`redis`)
+}
+
+type logHookStartTimeKey struct{}
+
+// 输出慢命令和出错命令日志，实现接口redis.Hook
+type logHook struct {
+	option LoggerOption
+	logger *zap.Logger
+}
+
+func newLogHook(option LoggerOption) *logHook {
+	return &logHook{
+		option: option,
+		logger: newLogger(`client`),
+	}
+}
+
+func (h *logHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, logHookStartTimeKey{}, time.Now()), nil
+}
+
+func (h *logHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.log(ctx, ``, cmd, cmd.Err())
+	return nil
+}
+
+func (h *logHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, logHookStartTimeKey{}, time.Now()), nil
+}
+
+func (h *logHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	var err error
+	for _, cmd := range cmds {
+		if e := cmd.Err(); e != nil && e != redis.Nil {
+			err = e
+			break
+		}
+	}
+
+	h.log(ctx, `pipeline: `, cmds[0], err, zap.Int(`cmds`, len(cmds)))
+	return nil
+}
+
+// 仅需输出日志时才格式化命令，参数prefix为命令前缀
+func (h *logHook) log(ctx context.Context, prefix string, cmd redis.Cmder, err error, fields ...zap.Field) {
+	start, ok := ctx.Value(logHookStartTimeKey{}).(time.Time)
+	if !ok {
+		return
+	}
+
+	elapsed := time.Since(start)
+	if h.option.LogError && err != nil && err != redis.Nil {
+		h.logger.Error(`redis命令出错`, append(fields, zap.String(`cmd`, truncateCmd(prefix+cmd.String())), zap.Duration(`elapsed`, elapsed), zap.Error(err))...)
+		return
+	}
+
+	if h.option.SlowThreshold > 0 && elapsed >= h.option.SlowThreshold {
+		h.logger.Warn(`redis慢命令`, append(fields, zap.String(`cmd`, truncateCmd(prefix+cmd.String())), zap.Duration(`elapsed`, elapsed))...)
+	}
+}
+
+// 截断过长的命令，避免输出大value
+func truncateCmd(cmd string) string {
+	if len(cmd) > 256 {
+		return cmd[:256] + `...`
+	}
+
+	return cmd
+}
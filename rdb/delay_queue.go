@@ -0,0 +1,431 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"time"
+)
+
+// 延迟队列使用的redis key，使用hash tag保证redis集群下位于同1个slot
+// delayed(zset)：待执行任务，score为执行时间戳(ms)
+// ready(list)：可执行任务
+// reserved(zset)：执行中任务，score为可见性超时时间戳(ms)
+// dead(zset)：死信任务，score为进入死信时间戳(ms)
+// jobs(hash)：任务数据，attempts(hash)：任务执行次数
+
+var (
+	//转移到期任务到ready，KEYS：delayed,ready,reserved,attempts,dead。ARGV：now,limit,maxAttempts
+	//可见性超时的任务，如果执行次数已达上限则转移到dead，否则立即重新投递
+	delayQueuePromoteScript = `
+	local now, limit, max = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3])
+	local n = 0
+	local ids = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now, 'LIMIT', 0, limit)
+	for _, id in ipairs(ids) do
+		redis.call('ZREM', KEYS[3], id)
+		if tonumber(redis.call('HGET', KEYS[4], id) or '0') >= max then
+			redis.call('ZADD', KEYS[5], now, id)
+		else
+			redis.call('RPUSH', KEYS[2], id)
+			n = n + 1
+		end
+	end
+	ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, limit)
+	for _, id in ipairs(ids) do
+		redis.call('ZREM', KEYS[1], id)
+		redis.call('RPUSH', KEYS[2], id)
+		n = n + 1
+	end
+	`
+
+	//返回转移的任务数量
+	delayQueuePromoteOnlyScript = redis.NewScript(delayQueuePromoteScript + `
+	return n
+	`)
+
+	//获取1个可执行任务，KEYS：delayed,ready,reserved,attempts,dead,jobs。ARGV：now,limit,maxAttempts,deadline
+	//返回{id,payload,attempts}，无任务返回nil
+	delayQueueReserveScript = redis.NewScript(delayQueuePromoteScript + `
+	while true do
+		local id = redis.call('LPOP', KEYS[2])
+		if not id then
+			return false
+		end
+		local p = redis.call('HGET', KEYS[6], id)
+		if p then
+			redis.call('ZADD', KEYS[3], ARGV[4], id)
+			return {id, p, redis.call('HINCRBY', KEYS[4], id, 1)}
+		end
+	end
+	`)
+
+	//确认任务完成，KEYS：reserved,jobs,attempts。ARGV：id。成功返回1，任务未被持有返回0
+	delayQueueAckScript = redis.NewScript(`
+	if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	redis.call('HDEL', KEYS[3], ARGV[1])
+	return 1
+	`)
+
+	//任务执行失败，KEYS：reserved,delayed,attempts,dead。ARGV：id,now,retryAt,maxAttempts
+	//返回1表示等待重试，2表示转移到dead，0表示任务未被持有
+	delayQueueNackScript = redis.NewScript(`
+	if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	if tonumber(redis.call('HGET', KEYS[3], ARGV[1]) or '0') >= tonumber(ARGV[4]) then
+		redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+		return 2
+	end
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+	return 1
+	`)
+
+	//重新投递死信任务，KEYS：dead,ready,attempts。ARGV：id。成功返回1
+	delayQueueRequeueScript = redis.NewScript(`
+	if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('HDEL', KEYS[3], ARGV[1])
+	redis.call('RPUSH', KEYS[2], ARGV[1])
+	return 1
+	`)
+)
+
+type DelayQueueOption struct {
+	Name              string        //队列名称
+	VisibilityTimeout time.Duration //可见性超时，任务被获取后超时未确认将重新投递，默认30s
+	MaxAttempts       int64         //最大执行次数，超过则转移到死信队列，默认3
+	RetryBackoff      time.Duration //重试间隔，每次重试间隔翻倍，默认1s
+	MaxRetryBackoff   time.Duration //最大重试间隔，默认10m
+	PollInterval      time.Duration //无任务时轮询间隔，默认1s
+	PromoteLimit      int64         //每次最多转移的到期任务数量，默认100
+	Workers           int           //消费者协程数量，默认1
+}
+
+func (o *DelayQueueOption) MustNormalize() *DelayQueueOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertOk(!_string.Empty(o.Name), `Name为空`)
+
+	if o.VisibilityTimeout <= 0 {
+		o.VisibilityTimeout = 30 * time.Second
+	}
+
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 1 * time.Second
+	}
+
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = 10 * time.Minute
+	}
+
+	if o.PollInterval <= 0 {
+		o.PollInterval = 1 * time.Second
+	}
+
+	if o.PromoteLimit <= 0 {
+		o.PromoteLimit = 100
+	}
+
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+
+	return o
+}
+
+type DelayJob struct {
+	ID       string
+	Payload  string
+	Attempts int64 //已执行次数，包括本次
+}
+
+type DelayQueueStats struct {
+	Delayed  int64 //待执行
+	Ready    int64 //可执行
+	Reserved int64 //执行中
+	Dead     int64 //死信
+}
+
+// DelayJobHandler 任务处理函数，返回nil则确认任务完成，否则等待重试
+type DelayJobHandler func(ctx context.Context, job *DelayJob) error
+
+// DelayQueue 延迟队列，任务至少执行1次，处理函数应保证幂等
+type DelayQueue struct {
+	option *DelayQueueOption
+	client redis.UniversalClient
+	logger *zap.Logger
+
+	delayedKey  string
+	readyKey    string
+	reservedKey string
+	deadKey     string
+	jobsKey     string
+	attemptsKey string
+}
+
+func MustNewDelayQueue(option *DelayQueueOption, client redis.UniversalClient) *DelayQueue {
+	o := option.MustNormalize()
+	util.AssertOk(client != nil, `client为空`)
+
+	prefix := fmt.Sprintf(`dq:{%v}:`, o.Name)
+	return &DelayQueue{
+		option:      o,
+		client:      client,
+		logger:      newLogger(`delay_queue`),
+		delayedKey:  prefix + `delayed`,
+		readyKey:    prefix + `ready`,
+		reservedKey: prefix + `reserved`,
+		deadKey:     prefix + `dead`,
+		jobsKey:     prefix + `jobs`,
+		attemptsKey: prefix + `attempts`,
+	}
+}
+
+func (q *DelayQueue) Option() *DelayQueueOption {
+	return q.option
+}
+
+// Enqueue 添加任务，delay后执行，返回任务ID
+func (q *DelayQueue) Enqueue(ctx context.Context, payload string, delay time.Duration) (string, error) {
+	return q.EnqueueAt(ctx, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt 添加任务，runAt时执行，返回任务ID
+func (q *DelayQueue) EnqueueAt(ctx context.Context, payload string, runAt time.Time) (string, error) {
+	id := shortuuid.New()
+	_, err := q.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, q.jobsKey, id, payload)
+		p.ZAdd(ctx, q.delayedKey, NewZ(runAt.UnixMilli(), id))
+		return nil
+	})
+
+	if err != nil {
+		return ``, err
+	}
+
+	return id, nil
+}
+
+func (q *DelayQueue) promoteKeys() []string {
+	return []string{q.delayedKey, q.readyKey, q.reservedKey, q.attemptsKey, q.deadKey}
+}
+
+// Promote 转移到期任务和可见性超时任务到可执行队列，返回转移的任务数量。Reserve()会自动调用
+func (q *DelayQueue) Promote(ctx context.Context) (int64, error) {
+	return delayQueuePromoteOnlyScript.Run(ctx, q.client, q.promoteKeys(),
+		time.Now().UnixMilli(), q.option.PromoteLimit, q.option.MaxAttempts).Int64()
+}
+
+// Reserve 获取1个可执行任务，任务在可见性超时前应调用Ack()/Nack()。无任务返回nil
+func (q *DelayQueue) Reserve(ctx context.Context) (*DelayJob, error) {
+	now := time.Now()
+	keys := append(q.promoteKeys(), q.jobsKey)
+	rs, err := delayQueueReserveScript.Run(ctx, q.client, keys,
+		now.UnixMilli(), q.option.PromoteLimit, q.option.MaxAttempts,
+		now.Add(q.option.VisibilityTimeout).UnixMilli()).Slice()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	job := &DelayJob{}
+	job.ID, _ = rs[0].(string)
+	job.Payload, _ = rs[1].(string)
+	job.Attempts, _ = rs[2].(int64)
+	return job, nil
+}
+
+// Ack 确认任务完成并删除任务。返回false表示任务未被持有，如已可见性超时
+func (q *DelayQueue) Ack(ctx context.Context, id string) (bool, error) {
+	return delayQueueAckScript.Run(ctx, q.client,
+		[]string{q.reservedKey, q.jobsKey, q.attemptsKey}, id).Bool()
+}
+
+// Nack 任务执行失败，按重试间隔延迟重试，执行次数达到上限则转移到死信队列。返回false表示任务未被持有
+func (q *DelayQueue) Nack(ctx context.Context, job *DelayJob) (bool, error) {
+	now := time.Now()
+	v, err := delayQueueNackScript.Run(ctx, q.client,
+		[]string{q.reservedKey, q.delayedKey, q.attemptsKey, q.deadKey},
+		job.ID, now.UnixMilli(), now.Add(q.RetryBackoff(job.Attempts)).UnixMilli(), q.option.MaxAttempts).Int64()
+
+	if err != nil {
+		return false, err
+	}
+
+	if v == 2 {
+		q.logger.Warn(`任务执行次数达到上限，转移到死信队列`, zap.String(`queue`, q.option.Name), zap.String(`id`, job.ID))
+	}
+
+	return v > 0, nil
+}
+
+// RetryBackoff 第attempts次执行失败后的重试间隔
+func (q *DelayQueue) RetryBackoff(attempts int64) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	d := float64(q.option.RetryBackoff) * math.Pow(2, float64(attempts-1))
+	if d > float64(q.option.MaxRetryBackoff) {
+		return q.option.MaxRetryBackoff
+	}
+
+	return time.Duration(d)
+}
+
+// DeadJobs 查询死信任务，按进入死信时间升序排列
+func (q *DelayQueue) DeadJobs(ctx context.Context, offset, count int64) ([]*DelayJob, error) {
+	opt := NewZRangeOption(math.MinInt64, math.MaxInt64).Limit(offset, count).Build()
+	ids, err := q.client.ZRangeByScore(ctx, q.deadKey, opt).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	payloads, err := q.client.HMGet(ctx, q.jobsKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, err := q.client.HMGet(ctx, q.attemptsKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*DelayJob, 0, len(ids))
+	for i, id := range ids {
+		job := &DelayJob{ID: id}
+		job.Payload, _ = payloads[i].(string)
+		if s, ok := attempts[i].(string); ok {
+			job.Attempts, _ = strconv.ParseInt(s, 10, 64)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// RequeueDead 重新投递死信任务，执行次数将清零
+func (q *DelayQueue) RequeueDead(ctx context.Context, id string) (bool, error) {
+	return delayQueueRequeueScript.Run(ctx, q.client,
+		[]string{q.deadKey, q.readyKey, q.attemptsKey}, id).Bool()
+}
+
+// Stats 队列统计
+func (q *DelayQueue) Stats(ctx context.Context) (*DelayQueueStats, error) {
+	var delayed, ready, reserved, dead *redis.IntCmd
+
+	_, err := q.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		delayed = p.ZCard(ctx, q.delayedKey)
+		ready = p.LLen(ctx, q.readyKey)
+		reserved = p.ZCard(ctx, q.reservedKey)
+		dead = p.ZCard(ctx, q.deadKey)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DelayQueueStats{
+		Delayed:  delayed.Val(),
+		Ready:    ready.Val(),
+		Reserved: reserved.Val(),
+		Dead:     dead.Val(),
+	}, nil
+}
+
+// Consume 启动Workers个协程消费任务，直到ctx取消。返回的管道在全部协程结束后关闭
+func (q *DelayQueue) Consume(ctx context.Context, handler DelayJobHandler) <-chan struct{} {
+	util.AssertOk(handler != nil, `handler为空`)
+
+	done := make(chan struct{})
+	g := async.NewRoutineGroup(q.option.Workers, func() {
+		for ctx.Err() == nil {
+			if !q.consumeOnce(ctx, handler) {
+				select {
+				case <-ctx.Done():
+				case <-time.After(q.option.PollInterval):
+				}
+			}
+		}
+	})
+
+	g.Start()
+	go func() {
+		g.Wait()
+		close(done)
+	}()
+
+	return done
+}
+
+// NewConsumer 创建消费者，调用Start()/Stop()启动和停止消费
+func (q *DelayQueue) NewConsumer(handler DelayJobHandler) *async.TaskRunner {
+	return async.NewTaskRunner(async.BgTaskFn(func(ctx context.Context) <-chan struct{} {
+		return q.Consume(ctx, handler)
+	}))
+}
+
+// 获取并执行1个任务，无任务或出错返回false
+func (q *DelayQueue) consumeOnce(ctx context.Context, handler DelayJobHandler) bool {
+	job, err := q.Reserve(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Error(`获取任务出错`, zap.String(`queue`, q.option.Name), zap.Error(err))
+		}
+		return false
+	}
+
+	if job == nil {
+		return false
+	}
+
+	if err = q.handle(ctx, handler, job); err != nil {
+		q.logger.Warn(`任务执行失败`, zap.String(`queue`, q.option.Name),
+			zap.String(`id`, job.ID), zap.Int64(`attempts`, job.Attempts), zap.Error(err))
+
+		//ctx可能已取消，使用新ctx确保nack
+		if _, err = q.Nack(context.Background(), job); err != nil {
+			q.logger.Error(`nack任务出错`, zap.String(`queue`, q.option.Name), zap.String(`id`, job.ID), zap.Error(err))
+		}
+		return true
+	}
+
+	if _, err = q.Ack(context.Background(), job.ID); err != nil {
+		q.logger.Error(`ack任务出错`, zap.String(`queue`, q.option.Name), zap.String(`id`, job.ID), zap.Error(err))
+	}
+
+	return true
+}
+
+func (q *DelayQueue) handle(ctx context.Context, handler DelayJobHandler, job *DelayJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(`任务执行崩溃->%v`, r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, q.option.VisibilityTimeout)
+	defer cancel()
+
+	return handler(ctx, job)
+}
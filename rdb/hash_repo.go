@@ -0,0 +1,434 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+)
+
+type HashRepoOption struct {
+	KeyPrefix string        //记录key前缀，记录key为KeyPrefix+id
+	TTL       time.Duration //记录默认TTL，小于等于0则不过期
+	Indexes   []string      //二级索引字段名称(redis标签值)，索引key为KeyPrefix+idx:字段名称:字段值，值为记录id集合
+}
+
+func (o *HashRepoOption) MustNormalize() *HashRepoOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertNotEmpty(o.KeyPrefix, `KeyPrefix为空`)
+	return o
+}
+
+// hash字段
+type hashField struct {
+	name  string //redis标签值
+	index int    //struct字段索引
+	kind  reflect.Kind
+}
+
+func (f *hashField) isInt() bool {
+	switch f.kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
+
+func (f *hashField) isFloat() bool {
+	return f.kind == reflect.Float32 || f.kind == reflect.Float64
+}
+
+// 转换为redis命令参数，自定义类型(如：type Status int)需转换为基础类型
+func (f *hashField) value(rv reflect.Value) interface{} {
+	v := rv.Field(f.index)
+	switch {
+	case f.kind == reflect.Bool:
+		return v.Bool()
+	case f.kind == reflect.String:
+		return v.String()
+	case f.isFloat():
+		return v.Float()
+	case f.kind >= reflect.Uint && f.kind <= reflect.Uint64:
+		return v.Uint()
+	default:
+		return v.Int()
+	}
+}
+
+// 转换为索引值
+func (f *hashField) indexValue(rv reflect.Value) string {
+	return formatHashValue(f.value(rv))
+}
+
+// 与go-redis写入命令参数的格式保持一致
+func formatHashValue(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return `1`
+		}
+		return `0`
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// HashRepo 将struct映射为redis hash，struct字段通过标签redis:"name"映射为hash字段，未设置标签的字段将被忽略
+// 字段类型仅支持bool/int/uint/float/string及其自定义类型，与HGetAll().Scan()一致
+// 二级索引通过读取旧值后更新索引集合实现，并发更新同1条记录的索引字段可能导致索引不一致
+// redis集群下记录key与索引key可能不在同1个slot，可设置KeyPrefix包含hash tag，如：{user}:
+type HashRepo[T any] struct {
+	option  *HashRepoOption
+	client  redis.UniversalClient
+	fields  []*hashField
+	byName  map[string]*hashField
+	indexes []*hashField
+}
+
+func MustNewHashRepo[T any](option *HashRepoOption, client redis.UniversalClient) *HashRepo[T] {
+	util.AssertOk(client != nil, `client为空`)
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	util.AssertOk(t.Kind() == reflect.Struct, `T必须为struct[%v]`, t)
+
+	r := &HashRepo[T]{
+		option: option.MustNormalize(),
+		client: client,
+		byName: make(map[string]*hashField),
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get(`redis`), `,`)[0]
+		if name == `` || name == `-` || !sf.IsExported() {
+			continue
+		}
+
+		f := &hashField{name: name, index: i, kind: sf.Type.Kind()}
+		util.AssertOk(f.kind == reflect.Bool || f.kind == reflect.String || f.isInt() || f.isFloat(),
+			`不支持的字段类型[%v.%v:%v]`, t.Name(), sf.Name, sf.Type)
+
+		r.fields = append(r.fields, f)
+		r.byName[name] = f
+	}
+
+	util.AssertOk(len(r.fields) > 0, `T未包含redis标签字段[%v]`, t)
+
+	for _, name := range r.option.Indexes {
+		f, ok := r.byName[name]
+		util.AssertOk(ok, `索引字段不存在[%v]`, name)
+		r.indexes = append(r.indexes, f)
+	}
+
+	return r
+}
+
+func (r *HashRepo[T]) Key(id string) string {
+	return r.option.KeyPrefix + id
+}
+
+func (r *HashRepo[T]) IndexKey(field, value string) string {
+	return r.option.KeyPrefix + `idx:` + field + `:` + value
+}
+
+// Get 查询记录，不存在返回nil
+func (r *HashRepo[T]) Get(ctx context.Context, id string) (*T, error) {
+	return r.scan(r.client.HGetAll(ctx, r.Key(id)))
+}
+
+// GetMany 批量查询记录，返回结果与参数ids顺序一致，不存在的记录为nil
+func (r *HashRepo[T]) GetMany(ctx context.Context, ids ...string) ([]*T, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HGetAll(ctx, r.Key(id))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]*T, len(ids))
+	for i, cmd := range cmds {
+		if rs[i], err = r.scan(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	return rs, nil
+}
+
+func (r *HashRepo[T]) scan(cmd *redis.StringStringMapCmd) (*T, error) {
+	if err := cmd.Err(); err != nil || len(cmd.Val()) == 0 {
+		return nil, err
+	}
+
+	v := new(T)
+	if err := cmd.Scan(v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Save 保存记录全部字段，参数ttl小于等于0则使用默认TTL
+func (r *HashRepo[T]) Save(ctx context.Context, id string, v *T, ttl time.Duration) error {
+	return r.SaveMany(ctx, map[string]*T{id: v}, ttl)
+}
+
+// SaveMany 批量保存记录全部字段，key为记录id。参数ttl小于等于0则使用默认TTL
+func (r *HashRepo[T]) SaveMany(ctx context.Context, items map[string]*T, ttl time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(items))
+	for id, v := range items {
+		util.AssertOk(v != nil, `记录为空[id=%v]`, id)
+		ids = append(ids, id)
+	}
+
+	olds, err := r.loadIndexValues(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			rv := reflect.ValueOf(items[id]).Elem()
+			r.hset(ctx, p, id, rv, r.fields, olds[i], ttl)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// Update 仅保存old与v不相同的字段，old为nil则保存全部字段，返回已保存的字段名称
+// 参数ttl小于等于0则使用默认TTL
+func (r *HashRepo[T]) Update(ctx context.Context, id string, old, v *T, ttl time.Duration) ([]string, error) {
+	util.AssertOk(v != nil, `v为空`)
+
+	rv := reflect.ValueOf(v).Elem()
+	changed := r.fields
+	if old != nil {
+		ov := reflect.ValueOf(old).Elem()
+		changed = nil
+		for _, f := range r.fields {
+			if !reflect.DeepEqual(rv.Field(f.index).Interface(), ov.Field(f.index).Interface()) {
+				changed = append(changed, f)
+			}
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	olds, err := r.loadIndexValues(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		r.hset(ctx, p, id, rv, changed, olds[0], ttl)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(changed))
+	for i, f := range changed {
+		names[i] = f.name
+	}
+
+	return names, nil
+}
+
+// 保存字段并更新索引，olds为记录已保存的索引字段值
+func (r *HashRepo[T]) hset(ctx context.Context, p redis.Pipeliner, id string, rv reflect.Value, fields []*hashField, olds map[string]string, ttl time.Duration) {
+	key := r.Key(id)
+	args := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		args = append(args, f.name, f.value(rv))
+	}
+
+	p.HSet(ctx, key, args...)
+
+	if ttl <= 0 {
+		ttl = r.option.TTL
+	}
+
+	if ttl > 0 {
+		p.Expire(ctx, key, ttl)
+	}
+
+	for _, f := range fields {
+		if !r.isIndex(f) {
+			continue
+		}
+
+		val := f.indexValue(rv)
+		if old, ok := olds[f.name]; ok && old != val {
+			p.SRem(ctx, r.IndexKey(f.name, old), id)
+		}
+
+		p.SAdd(ctx, r.IndexKey(f.name, val), id)
+	}
+}
+
+func (r *HashRepo[T]) isIndex(f *hashField) bool {
+	for _, idx := range r.indexes {
+		if idx == f {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 查询记录已保存的索引字段值，返回结果与参数ids顺序一致
+func (r *HashRepo[T]) loadIndexValues(ctx context.Context, ids []string) ([]map[string]string, error) {
+	rs := make([]map[string]string, len(ids))
+	if len(r.indexes) == 0 {
+		return rs, nil
+	}
+
+	names := make([]string, len(r.indexes))
+	for i, f := range r.indexes {
+		names[i] = f.name
+	}
+
+	cmds := make([]*redis.SliceCmd, len(ids))
+	_, err := r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HMGet(ctx, r.Key(id), names...)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		rs[i] = make(map[string]string)
+		for j, v := range cmd.Val() {
+			if s, ok := v.(string); ok {
+				rs[i][names[j]] = s
+			}
+		}
+	}
+
+	return rs, nil
+}
+
+func (r *HashRepo[T]) mustGetField(field string) *hashField {
+	f, ok := r.byName[field]
+	util.AssertOk(ok, `字段不存在[%v]`, field)
+	return f
+}
+
+// Incr 原子增加整数字段值，返回增加后的值。不更新索引和TTL
+func (r *HashRepo[T]) Incr(ctx context.Context, id, field string, delta int64) (int64, error) {
+	f := r.mustGetField(field)
+	util.AssertOk(f.isInt(), `字段不是整数类型[%v]`, field)
+	util.AssertOk(!r.isIndex(f), `不支持索引字段[%v]`, field)
+
+	return r.client.HIncrBy(ctx, r.Key(id), field, delta).Result()
+}
+
+// IncrFloat 原子增加浮点数字段值，返回增加后的值。不更新索引和TTL
+func (r *HashRepo[T]) IncrFloat(ctx context.Context, id, field string, delta float64) (float64, error) {
+	f := r.mustGetField(field)
+	util.AssertOk(f.isFloat(), `字段不是浮点数类型[%v]`, field)
+	util.AssertOk(!r.isIndex(f), `不支持索引字段[%v]`, field)
+
+	return r.client.HIncrByFloat(ctx, r.Key(id), field, delta).Result()
+}
+
+// Expire 设置记录TTL
+func (r *HashRepo[T]) Expire(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return r.client.Expire(ctx, r.Key(id), ttl).Result()
+}
+
+// Delete 删除记录及其索引
+func (r *HashRepo[T]) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	olds, err := r.loadIndexValues(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			p.Del(ctx, r.Key(id))
+			for field, val := range olds[i] {
+				p.SRem(ctx, r.IndexKey(field, val), id)
+			}
+		}
+		return nil
+	})
+
+	return err
+}
+
+// FindIDs 根据索引字段值查询记录id。已过期记录的id仍可能保留在索引里，可调用Find()过滤
+func (r *HashRepo[T]) FindIDs(ctx context.Context, field string, value interface{}) ([]string, error) {
+	f := r.mustGetField(field)
+	util.AssertOk(r.isIndex(f), `字段未设置索引[%v]`, field)
+
+	return r.client.SMembers(ctx, r.IndexKey(field, formatHashValue(value))).Result()
+}
+
+// Find 根据索引字段值查询记录，将从索引里删除已不存在的记录id
+func (r *HashRepo[T]) Find(ctx context.Context, field string, value interface{}) ([]*T, error) {
+	ids, err := r.FindIDs(ctx, field, value)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	items, err := r.GetMany(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*T
+	var missing []interface{}
+	for i, item := range items {
+		if item == nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+
+		rs = append(rs, item)
+	}
+
+	if len(missing) > 0 {
+		err = r.client.SRem(ctx, r.IndexKey(field, formatHashValue(value)), missing...).Err()
+	}
+
+	return rs, err
+}
@@ -0,0 +1,148 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"strings"
+	"time"
+)
+
+const idempotencyLockPrefix = `lock:`
+
+// 保存请求响应，如果仍然持有处理中锁。ARGV：lockValue,record,ttl
+var idempotencyCompleteScript = redis.NewScript(`
+	if redis.call('GET', KEYS[1]) == ARGV[1] then
+		redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+		return 1
+	end
+	return 0
+    `)
+
+// IdempotencyState 幂等键状态
+type IdempotencyState int
+
+const (
+	IdempotencyStateStarted    IdempotencyState = iota //首次请求，已获取处理中锁
+	IdempotencyStateInProgress                         //首次请求仍在处理中
+	IdempotencyStateCompleted                          //首次请求已完成，可重放响应
+)
+
+// IdempotencyRecord 保存的请求响应
+type IdempotencyRecord struct {
+	Status      int    `json:"status"`                //http状态码或grpc状态码
+	ContentType string `json:"contentType,omitempty"` //http响应内容类型或grpc响应消息名称
+	Body        []byte `json:"body,omitempty"`
+}
+
+type IdempotencyEntry struct {
+	Key    string
+	State  IdempotencyState
+	Record *IdempotencyRecord //State=IdempotencyStateCompleted时不为空
+	owner  string
+}
+
+type IdempotencyStoreOption struct {
+	KeyPrefix string        //redis key前缀，默认idem:
+	LockTTL   time.Duration //处理中锁TTL，超时后允许重新处理请求，默认1m
+	TTL       time.Duration //响应保存时长，默认24h
+}
+
+func (o *IdempotencyStoreOption) MustNormalize() *IdempotencyStoreOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if _string.Empty(o.KeyPrefix) {
+		o.KeyPrefix = `idem:`
+	}
+
+	if o.LockTTL <= 0 {
+		o.LockTTL = 1 * time.Minute
+	}
+
+	if o.TTL <= 0 {
+		o.TTL = 24 * time.Hour
+	}
+
+	return o
+}
+
+// IdempotencyStore 幂等键存储，保证同1个幂等键对应的请求仅处理1次，重复请求重放首次请求的响应
+// 使用流程：Begin()获取处理中锁，处理请求成功则调用Complete()保存响应，失败则调用Abort()以允许客户端重试
+type IdempotencyStore struct {
+	option *IdempotencyStoreOption
+	client redis.UniversalClient
+}
+
+func MustNewIdempotencyStore(option *IdempotencyStoreOption, client redis.UniversalClient) *IdempotencyStore {
+	util.AssertOk(client != nil, `client为空`)
+
+	return &IdempotencyStore{
+		option: option.MustNormalize(),
+		client: client,
+	}
+}
+
+func (s *IdempotencyStore) Option() *IdempotencyStoreOption {
+	return s.option
+}
+
+func (s *IdempotencyStore) Key(key string) string {
+	return s.option.KeyPrefix + key
+}
+
+// Begin 开始处理请求，根据返回的State决定处理请求，返回冲突或重放响应
+func (s *IdempotencyStore) Begin(ctx context.Context, key string) (*IdempotencyEntry, error) {
+	e := &IdempotencyEntry{Key: key, owner: shortuuid.New()}
+
+	ok, err := s.client.SetNX(ctx, s.Key(key), idempotencyLockPrefix+e.owner, s.option.LockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		e.State = IdempotencyStateStarted
+		return e, nil
+	}
+
+	v, err := s.client.Get(ctx, s.Key(key)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	//key可能刚好过期，视为处理中，由客户端重试
+	if err == redis.Nil || strings.HasPrefix(v, idempotencyLockPrefix) {
+		e.State = IdempotencyStateInProgress
+		return e, nil
+	}
+
+	record := &IdempotencyRecord{}
+	if err = json.Unmarshal([]byte(v), record); err != nil {
+		return nil, err
+	}
+
+	e.State = IdempotencyStateCompleted
+	e.Record = record
+	return e, nil
+}
+
+// Complete 保存请求响应，返回false表示处理中锁已超时
+func (s *IdempotencyStore) Complete(ctx context.Context, e *IdempotencyEntry, record *IdempotencyRecord) (bool, error) {
+	util.AssertOk(e.State == IdempotencyStateStarted, `未持有处理中锁`)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	return idempotencyCompleteScript.Run(ctx, s.client, []string{s.Key(e.Key)},
+		idempotencyLockPrefix+e.owner, data, s.option.TTL.Milliseconds()).Bool()
+}
+
+// Abort 放弃处理请求并释放处理中锁，客户端可使用相同幂等键重试
+func (s *IdempotencyStore) Abort(ctx context.Context, e *IdempotencyEntry) (bool, error) {
+	util.AssertOk(e.State == IdempotencyStateStarted, `未持有处理中锁`)
+	return DelEQ(ctx, s.client, s.Key(e.Key), idempotencyLockPrefix+e.owner)
+}
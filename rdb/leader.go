@@ -0,0 +1,221 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/bsm/redislock"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+type LeaderElectorOption struct {
+	Name          string        //选举名称，锁的key为leader:Name
+	Identity      string        //当前实例标识，应唯一，默认hostname-pid
+	LeaseTTL      time.Duration //领导者会话锁TTL，会话锁自动续约，默认10s
+	RetryInterval time.Duration //竞选时间间隔，默认2s
+}
+
+func (o *LeaderElectorOption) MustNormalize() *LeaderElectorOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertOk(!_string.Empty(o.Name), `Name为空`)
+
+	if _string.Empty(o.Identity) {
+		host, _ := os.Hostname()
+		o.Identity = fmt.Sprintf(`%v-%v`, host, os.Getpid())
+	}
+
+	if o.LeaseTTL <= 0 {
+		o.LeaseTTL = 10 * time.Second
+	}
+
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = 2 * time.Second
+	}
+
+	return o
+}
+
+// LeaderElector 领导者选举，通过持有会话锁成为领导者，会话锁续约失败后重新竞选
+// 实现接口async.Runner，调用Start()开始竞选，调用Stop()退出竞选并释放领导权
+type LeaderElector struct {
+	option *LeaderElectorOption
+	client redis.UniversalClient
+	locker *Locker
+	logger *zap.Logger
+	runner *async.TaskRunner
+
+	mu          sync.Mutex
+	lock        *SessionLock
+	cancel      context.CancelFunc //取消领导者ctx
+	resignUntil time.Time          //主动放弃领导权后，在此时间前不参与竞选
+	onElected   func(ctx context.Context)
+	onRevoked   func()
+}
+
+func MustNewLeaderElector(option *LeaderElectorOption, client redis.UniversalClient) *LeaderElector {
+	util.AssertOk(client != nil, `client为空`)
+
+	e := &LeaderElector{
+		option: option.MustNormalize(),
+		client: client,
+		logger: newLogger(`leader`),
+	}
+
+	e.locker = NewLocker(client).WithOnLockRefreshFailed(func(lockName string) { e.revoke() })
+	e.runner = async.NewTaskRunner(async.BgTaskFn(e.run))
+	return e
+}
+
+// WithOnElected 设置成为领导者回调函数，失去领导权时将取消ctx。回调函数不应长时间阻塞
+func (e *LeaderElector) WithOnElected(fn func(ctx context.Context)) *LeaderElector {
+	e.onElected = fn
+	return e
+}
+
+// WithOnRevoked 设置失去领导权回调函数，包括续约失败，主动放弃和停止竞选
+func (e *LeaderElector) WithOnRevoked(fn func()) *LeaderElector {
+	e.onRevoked = fn
+	return e
+}
+
+func (e *LeaderElector) Option() *LeaderElectorOption {
+	return e.option
+}
+
+// Key 领导者会话锁key，锁的值为领导者标识
+func (e *LeaderElector) Key() string {
+	return `leader:` + e.option.Name
+}
+
+// Identity 当前实例标识
+func (e *LeaderElector) Identity() string {
+	return e.option.Identity
+}
+
+func (e *LeaderElector) Start() {
+	e.runner.Start()
+}
+
+func (e *LeaderElector) Stop() {
+	e.runner.Stop()
+}
+
+func (e *LeaderElector) IsRunning() bool {
+	return e.runner.IsRunning()
+}
+
+// IsLeader 当前实例是否为领导者
+func (e *LeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.lock != nil && !e.lock.IsReleased()
+}
+
+// Leader 查询当前领导者标识，无领导者返回空字符串
+func (e *LeaderElector) Leader(ctx context.Context) (string, error) {
+	leader, err := e.client.Get(ctx, e.Key()).Result()
+	if err == redis.Nil {
+		return ``, nil
+	}
+
+	return leader, err
+}
+
+// Resign 主动放弃领导权，之后1个LeaseTTL内不参与竞选，以便其他实例成为领导者
+func (e *LeaderElector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	e.resignUntil = time.Now().Add(e.option.LeaseTTL)
+	lock := e.lock
+	e.mu.Unlock()
+
+	if lock == nil {
+		return nil
+	}
+
+	err := lock.Release(ctx)
+	e.revoke()
+	return err
+}
+
+func (e *LeaderElector) run(ctx context.Context) <-chan struct{} {
+	return async.NewRunIntervalHelper(e.option.RetryInterval).
+		WithInitRunDelay(1 * time.Millisecond).
+		WithContext(ctx).
+		Run(func(c async.Context) {
+			if c.Done() {
+				e.revoke()
+				return
+			}
+
+			e.campaign(ctx)
+		})
+}
+
+func (e *LeaderElector) campaign(ctx context.Context) {
+	e.mu.Lock()
+	lock, resignUntil := e.lock, e.resignUntil
+	e.mu.Unlock()
+
+	if lock != nil {
+		if !lock.IsReleased() {
+			return
+		}
+
+		e.revoke() //续约失败
+	}
+
+	if time.Now().Before(resignUntil) {
+		return
+	}
+
+	lock, err := e.locker.ObtainSessionLockWithOwner(ctx, e.Key(), e.option.Identity, e.option.LeaseTTL)
+	if err == redislock.ErrNotObtained {
+		return
+	}
+
+	if err != nil {
+		if ctx.Err() == nil {
+			e.logger.Error(`竞选领导者出错`, zap.String(`name`, e.option.Name), zap.Error(err))
+		}
+		return
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	e.mu.Lock()
+	e.lock, e.cancel = lock, cancel
+	e.mu.Unlock()
+
+	e.logger.Info(`成为领导者`, zap.String(`name`, e.option.Name), zap.String(`identity`, e.option.Identity))
+	if e.onElected != nil {
+		e.onElected(leaderCtx)
+	}
+}
+
+// 失去领导权，释放会话锁并取消领导者ctx
+func (e *LeaderElector) revoke() {
+	e.mu.Lock()
+	lock, cancel := e.lock, e.cancel
+	e.lock, e.cancel = nil, nil
+	e.mu.Unlock()
+
+	if lock == nil {
+		return
+	}
+
+	cancel()
+	if err := lock.ReleaseWithTimeout(3 * time.Second); err != nil {
+		e.logger.Warn(`释放领导者会话锁出错`, zap.String(`name`, e.option.Name), zap.Error(err))
+	}
+
+	e.logger.Info(`失去领导权`, zap.String(`name`, e.option.Name), zap.String(`identity`, e.option.Identity))
+	if e.onRevoked != nil {
+		e.onRevoked()
+	}
+}
@@ -0,0 +1,359 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	LeaderboardModeBest   = `best`   //保留最好成绩
+	LeaderboardModeLatest = `latest` //保留最新成绩
+	LeaderboardModeSum    = `sum`    //累加成绩
+
+	LeaderboardPeriodAll     = ``        //总榜
+	LeaderboardPeriodDaily   = `daily`   //日榜
+	LeaderboardPeriodWeekly  = `weekly`  //周榜，周一开始
+	LeaderboardPeriodMonthly = `monthly` //月榜
+)
+
+// 同分排序时间占用的bit数，编码后的分数=成绩*2^22+时间值，成绩绝对值应小于2^31
+const leaderboardTieBits = 22
+
+// 总榜同分排序时间起点，时间单位为分钟，可表示约7.9年
+var leaderboardTieEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// 提交成绩，KEYS[1]为排行榜key，ARGV：member,mode,score,tie,scale,asc,ttl(ms)
+// 返回{是否更新,成绩}，成绩使用字符串返回避免丢失小数
+var leaderboardSubmitScript = redis.NewScript(`
+	local mode, score, tie, scale, asc, ttl = ARGV[2], tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), ARGV[6] == '1', tonumber(ARGV[7])
+	local cur = redis.call('ZSCORE', KEYS[1], ARGV[1])
+	local new = score
+	if cur then
+		--仅启用同分排序时需去掉时间值，否则会截断小数成绩
+		local old = tonumber(cur)
+		if scale > 1 then
+			old = math.floor(old / scale)
+		end
+		if mode == 'sum' then
+			new = old + score
+		elseif mode == 'best' and ((asc and score >= old) or (not asc and score <= old)) then
+			return {0, string.format('%.17g', old)}
+		end
+	end
+
+	redis.call('ZADD', KEYS[1], string.format('%.17g', new * scale + tie), ARGV[1])
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+	return {1, string.format('%.17g', new)}
+    `)
+
+type LeaderboardOption struct {
+	Name      string //排行榜名称
+	KeyPrefix string //redis key前缀，默认lb:
+	Mode      string //成绩提交模式：best/latest/sum，默认best
+	Asc       bool   //是否成绩越小排名越高，如：比赛用时
+	Period    string //周期：daily/weekly/monthly，为空则为总榜
+	Retention int    //周期榜过期后保留的周期数，默认1，即保留上1周期的排行榜
+	TieBreak  bool   //同分时先达到此成绩的排名更高，启用后成绩只能为整数且绝对值小于2^31
+}
+
+func (o *LeaderboardOption) MustNormalize() *LeaderboardOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertNotEmpty(o.Name, `Name为空`)
+
+	if _string.Empty(o.KeyPrefix) {
+		o.KeyPrefix = `lb:`
+	}
+
+	if _string.Empty(o.Mode) {
+		o.Mode = LeaderboardModeBest
+	}
+
+	o.Mode = strings.ToLower(o.Mode)
+	util.AssertOk(o.Mode == LeaderboardModeBest || o.Mode == LeaderboardModeLatest || o.Mode == LeaderboardModeSum,
+		`无效Mode[%v]`, o.Mode)
+
+	o.Period = strings.ToLower(o.Period)
+	util.AssertOk(o.Period == LeaderboardPeriodAll || o.Period == LeaderboardPeriodDaily ||
+		o.Period == LeaderboardPeriodWeekly || o.Period == LeaderboardPeriodMonthly, `无效Period[%v]`, o.Period)
+
+	if o.Retention <= 0 {
+		o.Retention = 1
+	}
+
+	return o
+}
+
+type LeaderboardEntry struct {
+	Member string
+	Score  float64
+	Rank   int64 //排名，从1开始
+}
+
+// Leaderboard 基于ZSET的排行榜，周期榜按周期使用不同的key并自动过期
+type Leaderboard struct {
+	option *LeaderboardOption
+	client redis.UniversalClient
+	at     time.Time //所在周期的时间，为空则为当前时间
+}
+
+func MustNewLeaderboard(option *LeaderboardOption, client redis.UniversalClient) *Leaderboard {
+	util.AssertOk(client != nil, `client为空`)
+
+	return &Leaderboard{
+		option: option.MustNormalize(),
+		client: client,
+	}
+}
+
+// At 返回参数t所在周期的排行榜，如：昨天的日榜。总榜返回自身
+func (l *Leaderboard) At(t time.Time) *Leaderboard {
+	if l.option.Period == LeaderboardPeriodAll {
+		return l
+	}
+
+	return &Leaderboard{option: l.option, client: l.client, at: t}
+}
+
+func (l *Leaderboard) now() time.Time {
+	if l.at.IsZero() {
+		return time.Now()
+	}
+
+	return l.at
+}
+
+// 周期开始和结束时间
+func (l *Leaderboard) bucket(t time.Time) (start, end time.Time) {
+	y, m, d := t.Date()
+	switch l.option.Period {
+	case LeaderboardPeriodDaily:
+		start = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 0, 1)
+	case LeaderboardPeriodWeekly:
+		offset := (int(t.Weekday()) + 6) % 7
+		start = time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 0, 7)
+	case LeaderboardPeriodMonthly:
+		start = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		end = start.AddDate(0, 1, 0)
+	default:
+		start = leaderboardTieEpoch
+	}
+
+	return
+}
+
+// Key 当前周期排行榜key，如：lb:score:20240101，lb:score:2024W01，lb:score:202401
+func (l *Leaderboard) Key() string {
+	key := l.option.KeyPrefix + l.option.Name
+	t := l.now()
+	switch l.option.Period {
+	case LeaderboardPeriodDaily:
+		return key + `:` + t.Format(`20060102`)
+	case LeaderboardPeriodWeekly:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf(`%v:%04dW%02d`, key, y, w)
+	case LeaderboardPeriodMonthly:
+		return key + `:` + t.Format(`200601`)
+	default:
+		return key
+	}
+}
+
+func (l *Leaderboard) scale() float64 {
+	if l.option.TieBreak {
+		return 1 << leaderboardTieBits
+	}
+
+	return 1
+}
+
+// 同分排序时间值，降序排名时越早提交值越大
+func (l *Leaderboard) tie(t time.Time) int64 {
+	if !l.option.TieBreak {
+		return 0
+	}
+
+	start, _ := l.bucket(t)
+	elapsed := int64(t.Sub(start) / time.Second)
+	if l.option.Period == LeaderboardPeriodAll {
+		elapsed = int64(t.Sub(start) / time.Minute)
+	}
+
+	max := int64(1)<<leaderboardTieBits - 1
+	if elapsed < 0 {
+		elapsed = 0
+	} else if elapsed > max {
+		elapsed = max
+	}
+
+	if l.option.Asc {
+		return elapsed
+	}
+
+	return max - elapsed
+}
+
+// 周期榜TTL，总榜不过期
+func (l *Leaderboard) ttl(t time.Time) time.Duration {
+	if l.option.Period == LeaderboardPeriodAll {
+		return 0
+	}
+
+	start, end := l.bucket(t)
+	return end.Add(time.Duration(l.option.Retention) * end.Sub(start)).Sub(time.Now())
+}
+
+func (l *Leaderboard) decode(score float64) float64 {
+	if l.option.TieBreak {
+		return math.Floor(score / l.scale())
+	}
+
+	return score
+}
+
+// Submit 提交成绩，返回提交后的成绩及是否已更新。best模式下未超过已有成绩则不更新
+func (l *Leaderboard) Submit(ctx context.Context, member string, score float64) (float64, bool, error) {
+	util.AssertNotEmpty(member, `member为空`)
+	if l.option.TieBreak {
+		util.AssertOk(score == math.Trunc(score) && math.Abs(score) < 1<<31, `启用TieBreak时成绩只能为整数且绝对值小于2^31[%v]`, score)
+	}
+
+	t := l.now()
+	asc := 0
+	if l.option.Asc {
+		asc = 1
+	}
+
+	rs, err := leaderboardSubmitScript.Run(ctx, l.client, []string{l.Key()},
+		member, l.option.Mode, score, l.tie(t), l.scale(), asc, l.ttl(t).Milliseconds()).Slice()
+	if err != nil {
+		return 0, false, err
+	}
+
+	v, err := strconv.ParseFloat(rs[1].(string), 64)
+	return v, rs[0].(int64) == 1, err
+}
+
+// Get 查询成员排名和成绩，不存在返回nil
+func (l *Leaderboard) Get(ctx context.Context, member string) (*LeaderboardEntry, error) {
+	key := l.Key()
+
+	var rankCmd *redis.IntCmd
+	var scoreCmd *redis.FloatCmd
+	_, err := l.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		if l.option.Asc {
+			rankCmd = p.ZRank(ctx, key, member)
+		} else {
+			rankCmd = p.ZRevRank(ctx, key, member)
+		}
+		scoreCmd = p.ZScore(ctx, key, member)
+		return nil
+	})
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeaderboardEntry{
+		Member: member,
+		Score:  l.decode(scoreCmd.Val()),
+		Rank:   rankCmd.Val() + 1,
+	}, nil
+}
+
+// Top 分页查询排名，参数offset从0开始
+func (l *Leaderboard) Top(ctx context.Context, offset, count int64) ([]*LeaderboardEntry, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+
+	return l.rangeByRank(ctx, offset, offset+count-1)
+}
+
+// Around 查询成员及其前后各n名，成员不存在返回nil
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]*LeaderboardEntry, error) {
+	var rank int64
+	var err error
+	if l.option.Asc {
+		rank, err = l.client.ZRank(ctx, l.Key(), member).Result()
+	} else {
+		rank, err = l.client.ZRevRank(ctx, l.Key(), member).Result()
+	}
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+
+	return l.rangeByRank(ctx, start, rank+n)
+}
+
+func (l *Leaderboard) rangeByRank(ctx context.Context, start, stop int64) ([]*LeaderboardEntry, error) {
+	var zs []redis.Z
+	var err error
+	if l.option.Asc {
+		zs, err = l.client.ZRangeWithScores(ctx, l.Key(), start, stop).Result()
+	} else {
+		zs, err = l.client.ZRevRangeWithScores(ctx, l.Key(), start, stop).Result()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]*LeaderboardEntry, len(zs))
+	for i, z := range zs {
+		rs[i] = &LeaderboardEntry{
+			Member: fmt.Sprint(z.Member),
+			Score:  l.decode(z.Score),
+			Rank:   start + int64(i) + 1,
+		}
+	}
+
+	return rs, nil
+}
+
+// Count 参与排名的成员数量
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return l.client.ZCard(ctx, l.Key()).Result()
+}
+
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+
+	return l.client.ZRem(ctx, l.Key(), args...).Err()
+}
+
+func (l *Leaderboard) Reset(ctx context.Context) error {
+	return l.client.Del(ctx, l.Key()).Err()
+}
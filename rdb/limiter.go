@@ -2,9 +2,12 @@ package rdb
 
 import (
 	"context"
+	"fmt"
 	"github.com/bingooh/b-go-util/_string"
 	"github.com/bingooh/b-go-util/util"
 	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"go.uber.org/zap"
 	"time"
 )
 
@@ -25,10 +28,10 @@ var tfIncrScript = redis.NewScript(`
 type RateLimiter struct {
 	tooOftenPrefix string
 	tooFreqPrefix  string
-	client         *redis.Client
+	client         redis.UniversalClient
 }
 
-func MustNewRateLimiter(prefix string, client *redis.Client) *RateLimiter {
+func MustNewRateLimiter(prefix string, client redis.UniversalClient) *RateLimiter {
 	util.AssertOk(!_string.Empty(prefix), `prefix is empty`)
 
 	return &RateLimiter{
@@ -71,3 +74,260 @@ func (r *RateLimiter) TooOftenKey(key string) string {
 func (r *RateLimiter) TooFreqKey(key string) string {
 	return r.tooFreqPrefix + key
 }
+
+const (
+	SLIDING_WINDOW_PREFIX = `sw_`
+	GCRA_PREFIX           = `gc_`
+	TOKEN_BUCKET_PREFIX   = `tb_`
+)
+
+// 限流算法
+type LimitAlgorithm string
+
+const (
+	LimitAlgorithmSlidingWindow LimitAlgorithm = `sliding_window` //滑动窗口日志
+	LimitAlgorithmGCRA          LimitAlgorithm = `gcra`           //通用信元速率算法
+	LimitAlgorithmTokenBucket   LimitAlgorithm = `token_bucket`   //令牌桶
+)
+
+var (
+	//以下脚本时间单位均为毫秒，当前时间由客户端传入
+	//脚本统一返回{allowed,remaining,retryAfter,resetAfter}，retryAfter为-1表示请求数超过限制，永远无法通过
+
+	//滑动窗口日志，使用zset保存窗口内每次请求，ARGV：now,period,limit,n,member
+	slidingWindowScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local period = tonumber(ARGV[2])
+	local limit = tonumber(ARGV[3])
+	local n = tonumber(ARGV[4])
+
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+	local count = redis.call('ZCARD', KEYS[1])
+	local allowed = 0
+	if count + n <= limit then
+		for i = 1, n do
+			redis.call('ZADD', KEYS[1], now, ARGV[5] .. ':' .. i)
+		end
+		count = count + n
+		allowed = 1
+	end
+
+	local retry = 0
+	if allowed == 0 then
+		if n > limit then
+			retry = -1
+		else
+			local e = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+			retry = tonumber(e[2]) + period - now
+		end
+	end
+
+	local reset = 0
+	local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	if newest[2] then
+		reset = tonumber(newest[2]) + period - now
+		redis.call('PEXPIRE', KEYS[1], period)
+	end
+
+	return {allowed, math.max(limit - count, 0), retry, reset}
+    `)
+
+	//GCRA，仅保存理论到达时间(tat)，ARGV：now,period,limit,burst,n
+	gcraScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local interval = tonumber(ARGV[2]) / tonumber(ARGV[3])
+	local burst = tonumber(ARGV[4])
+	local n = tonumber(ARGV[5])
+
+	if n > burst then
+		return {0, 0, -1, 0}
+	end
+
+	local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+	tat = math.max(tat, now)
+
+	local newTat = tat + interval * n
+	local diff = now - (newTat - interval * burst)
+	if diff < 0 then
+		return {0, 0, math.ceil(-diff), math.ceil(tat - now)}
+	end
+
+	local reset = math.ceil(newTat - now)
+	redis.call('SET', KEYS[1], newTat, 'PX', math.max(reset, 1))
+	return {1, math.floor(diff / interval), 0, reset}
+    `)
+
+	//令牌桶，hash字段：tk-令牌数，ts-上次填充时间，ARGV：now,period,limit,burst,n
+	tokenBucketScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[3]) / tonumber(ARGV[2])
+	local capacity = tonumber(ARGV[4])
+	local n = tonumber(ARGV[5])
+
+	if n > capacity then
+		return {0, 0, -1, 0}
+	end
+
+	local b = redis.call('HMGET', KEYS[1], 'tk', 'ts')
+	local tokens = tonumber(b[1]) or capacity
+	local ts = tonumber(b[2]) or now
+	if now > ts then
+		tokens = math.min(capacity, tokens + (now - ts) * rate)
+		ts = now
+	end
+
+	local allowed = 0
+	local retry = 0
+	if tokens >= n then
+		tokens = tokens - n
+		allowed = 1
+	else
+		retry = math.ceil((n - tokens) / rate)
+	end
+
+	local reset = math.ceil((capacity - tokens) / rate)
+	redis.call('HSET', KEYS[1], 'tk', tokens, 'ts', ts)
+	redis.call('PEXPIRE', KEYS[1], math.max(reset, 1))
+	return {allowed, math.floor(tokens), retry, reset}
+    `)
+)
+
+type LimiterOption struct {
+	Algorithm LimitAlgorithm //限流算法，默认sliding_window
+	Limit     int64          //每个周期允许的请求数
+	Period    time.Duration  //周期时长，默认1s
+	Burst     int64          //最大突发请求数，仅gcra/token_bucket使用，默认等于Limit
+	FailOpen  bool           //redis出错时是否放行，默认拒绝
+}
+
+func (o *LimiterOption) MustNormalize() *LimiterOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertOk(o.Limit > 0, `Limit<=0`)
+
+	if o.Algorithm == `` {
+		o.Algorithm = LimitAlgorithmSlidingWindow
+	}
+
+	switch o.Algorithm {
+	case LimitAlgorithmSlidingWindow, LimitAlgorithmGCRA, LimitAlgorithmTokenBucket:
+	default:
+		panic(util.NewAssertFailError(`无效Algorithm[%v]`, o.Algorithm))
+	}
+
+	if o.Period <= 0 {
+		o.Period = 1 * time.Second
+	}
+
+	util.AssertOk(o.Period >= time.Millisecond, `Period<1ms`)
+
+	if o.Burst <= 0 {
+		o.Burst = o.Limit
+	}
+
+	return o
+}
+
+// 限流结果
+type LimitResult struct {
+	Allowed    bool          //是否放行
+	Limit      int64         //每个周期允许的请求数
+	Remaining  int64         //剩余可用请求数
+	RetryAfter time.Duration //被拒绝后需等待多久重试，-1表示请求数超过限制永远无法通过
+	ResetAfter time.Duration //需等待多久配额完全恢复
+}
+
+// ResetAt 配额完全恢复时间
+func (r *LimitResult) ResetAt() time.Time {
+	return time.Now().Add(r.ResetAfter)
+}
+
+// Limiter 基于lua脚本的限流器，支持滑动窗口日志/GCRA/令牌桶算法
+type Limiter struct {
+	prefix string
+	option *LimiterOption
+	logger *zap.Logger
+	client redis.UniversalClient
+}
+
+func MustNewLimiter(prefix string, option *LimiterOption, client redis.UniversalClient) *Limiter {
+	util.AssertOk(!_string.Empty(prefix), `prefix is empty`)
+	util.AssertOk(client != nil, `client为空`)
+
+	o := option.MustNormalize()
+	switch o.Algorithm {
+	case LimitAlgorithmGCRA:
+		prefix = GCRA_PREFIX + prefix
+	case LimitAlgorithmTokenBucket:
+		prefix = TOKEN_BUCKET_PREFIX + prefix
+	default:
+		prefix = SLIDING_WINDOW_PREFIX + prefix
+	}
+
+	return &Limiter{
+		prefix: prefix,
+		option: o,
+		logger: newLogger(`limiter`),
+		client: client,
+	}
+}
+
+func (l *Limiter) Option() *LimiterOption {
+	return l.option
+}
+
+func (l *Limiter) Key(key string) string {
+	return l.prefix + key
+}
+
+// Allow 请求1次
+func (l *Limiter) Allow(ctx context.Context, key string) (*LimitResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 请求n次。如果redis出错，则根据FailOpen决定是否放行，同时返回错误
+func (l *Limiter) AllowN(ctx context.Context, key string, n int64) (*LimitResult, error) {
+	util.AssertOk(n > 0, `n<=0`)
+
+	o := l.option
+	now := time.Now().UnixMilli()
+	period := o.Period.Milliseconds()
+
+	var cmd *redis.Cmd
+	switch o.Algorithm {
+	case LimitAlgorithmGCRA:
+		cmd = gcraScript.Run(ctx, l.client, []string{l.Key(key)}, now, period, o.Limit, o.Burst, n)
+	case LimitAlgorithmTokenBucket:
+		cmd = tokenBucketScript.Run(ctx, l.client, []string{l.Key(key)}, now, period, o.Limit, o.Burst, n)
+	default:
+		cmd = slidingWindowScript.Run(ctx, l.client, []string{l.Key(key)}, now, period, o.Limit, n, shortuuid.New())
+	}
+
+	rs, err := cmd.Int64Slice()
+	if err == nil && len(rs) != 4 {
+		err = fmt.Errorf(`invalid limiter script result[%v]`, rs)
+	}
+
+	if err != nil {
+		l.logger.Error(`限流脚本执行出错`, zap.String(`key`, l.Key(key)), zap.Bool(`failOpen`, o.FailOpen), zap.Error(err))
+		return &LimitResult{Allowed: o.FailOpen, Limit: o.Limit}, err
+	}
+
+	r := &LimitResult{
+		Allowed:    rs[0] == 1,
+		Limit:      o.Limit,
+		Remaining:  rs[1],
+		RetryAfter: time.Duration(rs[2]) * time.Millisecond,
+		ResetAfter: time.Duration(rs[3]) * time.Millisecond,
+	}
+
+	if rs[2] < 0 {
+		r.RetryAfter = -1
+	}
+
+	return r, nil
+}
+
+// Reset 重置限流记录
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.client.Del(ctx, l.Key(key)).Err()
+}
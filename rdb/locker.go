@@ -17,6 +17,8 @@ type OnLockRefreshFailed func(lockName string)
 type Locker struct {
 	*redislock.Client
 
+	client                redis.UniversalClient
+	enableFencingToken    bool
 	retryLimit            int
 	retryInterval         time.Duration
 	onLockRefreshFailedFn OnLockRefreshFailed
@@ -25,6 +27,7 @@ type Locker struct {
 func NewLocker(client redis.UniversalClient) *Locker {
 	return &Locker{
 		Client: redislock.New(client),
+		client: client,
 	}
 }
 
@@ -34,6 +37,13 @@ func (l *Locker) WithOnLockRefreshFailed(fn OnLockRefreshFailed) *Locker {
 	return l
 }
 
+// 设置是否启用防护令牌，启用后每次获取会话锁将生成1个单调递增的防护令牌，见 SessionLock.FencingToken()
+// 防护令牌计数器key为lockName:fencing，redis集群下lockName应包含hash tag，如：{task}:lock
+func (l *Locker) WithFencingToken(enable bool) *Locker {
+	l.enableFencingToken = enable
+	return l
+}
+
 // 设置重试获取锁
 func (l *Locker) WithRetryOption(limit int, interval time.Duration) *Locker {
 	l.retryLimit = limit
@@ -41,13 +51,10 @@ func (l *Locker) WithRetryOption(limit int, interval time.Duration) *Locker {
 	return l
 }
 
-// 获取锁
-func (l *Locker) obtainLock(ctx context.Context, lockName string, lockTTL time.Duration) (lock *redislock.Lock, err error) {
-	//由于redislock库的问题(redislock.go/64行)，lockTTL参数值将设置给ctx作为其超时时间
-	//假设设置lockTTL=1秒，重试策略为每秒重试1次，最多10次。则实际会在1秒后返回获取锁失败，即lockTTL超时导致获取锁失败
-	//以下自定义重试逻辑
+// 获取锁，参数obtain获取锁失败应返回redislock.ErrNotObtained
+func (l *Locker) obtainLock(ctx context.Context, lockTTL time.Duration, obtain func() (lockHandle, error)) (lock lockHandle, err error) {
 	if l.retryLimit <= 0 {
-		return l.Client.Obtain(ctx, lockName, lockTTL, nil)
+		return obtain()
 	}
 
 	if l.retryInterval <= 0 {
@@ -62,7 +69,7 @@ func (l *Locker) obtainLock(ctx context.Context, lockName string, lockTTL time.D
 			return
 		}
 
-		lock, err = l.Client.Obtain(ctx, lockName, lockTTL, nil)
+		lock, err = obtain()
 		if err == nil || err != redislock.ErrNotObtained {
 			c.Abort()
 		}
@@ -77,7 +84,22 @@ func (l *Locker) obtainLock(ctx context.Context, lockName string, lockTTL time.D
 
 // 获取会话锁，会话锁会自动续约锁
 func (l *Locker) ObtainSessionLock(ctx context.Context, lockName string, lockTTL time.Duration) (*SessionLock, error) {
-	lock, err := l.obtainLock(ctx, lockName, lockTTL)
+	if l.enableFencingToken {
+		return l.ObtainSessionLockWithOwner(ctx, lockName, ``, lockTTL)
+	}
+
+	//由于redislock库的问题(redislock.go/64行)，lockTTL参数值将设置给ctx作为其超时时间
+	//假设设置lockTTL=1秒，重试策略为每秒重试1次，最多10次。则实际会在1秒后返回获取锁失败，即lockTTL超时导致获取锁失败
+	//以下自定义重试逻辑
+	lock, err := l.obtainLock(ctx, lockTTL, func() (lockHandle, error) {
+		lock, err := l.Client.Obtain(ctx, lockName, lockTTL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &redisLockHandle{Lock: lock}, nil
+	})
+
 	if err != nil {
 		return nil, err
 	}
@@ -85,10 +107,31 @@ func (l *Locker) ObtainSessionLock(ctx context.Context, lockName string, lockTTL
 	return newSessionLock(lock, lockTTL, l.onLockRefreshFailedFn), nil
 }
 
+// 会话锁底层的锁
+type lockHandle interface {
+	Key() string
+	Token() string
+	Metadata() string
+	TTL(ctx context.Context) (time.Duration, error)
+	Refresh(ctx context.Context, ttl time.Duration) error
+	Release(ctx context.Context) error
+}
+
+type redisLockHandle struct {
+	*redislock.Lock
+}
+
+func (h *redisLockHandle) Refresh(ctx context.Context, ttl time.Duration) error {
+	return h.Lock.Refresh(ctx, ttl, nil)
+}
+
 // 自动续约锁(会话锁)
+// 仅ObtainSessionLock()获取的普通锁(未启用防护令牌)的Lock不为nil，其他锁的Lock为nil，应使用SessionLock的方法
 type SessionLock struct {
 	*redislock.Lock
 
+	lock                lockHandle
+	fencingToken        int64
 	logger              *zap.Logger
 	lockTTL             time.Duration
 	isReleased          *util.AtomicBool
@@ -96,27 +139,66 @@ type SessionLock struct {
 	onLockRefreshFailed OnLockRefreshFailed
 }
 
-func newSessionLock(lock *redislock.Lock, lockTTL time.Duration, onLockRefreshFailed OnLockRefreshFailed) *SessionLock {
+func newSessionLock(lock lockHandle, lockTTL time.Duration, onLockRefreshFailed OnLockRefreshFailed) *SessionLock {
 	s := &SessionLock{
 		logger:              newLogger(`session_lock`),
-		Lock:                lock,
+		lock:                lock,
 		lockTTL:             lockTTL,
 		isReleased:          util.NewAtomicBool(false),
 		onLockRefreshFailed: onLockRefreshFailed,
 	}
 
+	if h, ok := lock.(*redisLockHandle); ok {
+		s.Lock = h.Lock
+	}
+
 	s.refreshRunner = async.NewTaskRunner(async.BgTaskFn(s.refreshBgTask))
 	s.refreshRunner.Start()
 	return s
 }
 
+// Key 锁名称
+func (s *SessionLock) Key() string {
+	return s.lock.Key()
+}
+
+// Token 锁持有者标识
+func (s *SessionLock) Token() string {
+	return s.lock.Token()
+}
+
+// Metadata 锁元数据，仅ObtainSessionLock()获取的普通锁可能不为空
+func (s *SessionLock) Metadata() string {
+	return s.lock.Metadata()
+}
+
+// TTL 锁剩余有效时长，锁已过期或被其他持有者持有返回0
+func (s *SessionLock) TTL(ctx context.Context) (time.Duration, error) {
+	return s.lock.TTL(ctx)
+}
+
+// Refresh 手动续约锁，参数opt仅用于ObtainSessionLock()获取的普通锁，其他锁忽略
+func (s *SessionLock) Refresh(ctx context.Context, ttl time.Duration, opt *redislock.Options) error {
+	if s.Lock != nil {
+		return s.Lock.Refresh(ctx, ttl, opt)
+	}
+
+	return s.lock.Refresh(ctx, ttl)
+}
+
+// FencingToken 防护令牌，未启用防护令牌则返回0
+// 锁可能因GC停顿或网络分区过期，而持有者仍然认为持有锁。下游写操作应校验防护令牌，拒绝旧令牌，见 SetFenced()
+func (s *SessionLock) FencingToken() int64 {
+	return s.fencingToken
+}
+
 func (s *SessionLock) Release(ctx context.Context) error {
 	if !s.isReleased.CASwap(false) {
 		return nil
 	}
 
 	s.refreshRunner.Stop()
-	return s.Lock.Release(ctx)
+	return s.lock.Release(ctx)
 }
 
 func (s *SessionLock) ReleaseWithTimeout(timeout time.Duration) error {
@@ -142,17 +224,17 @@ func (s *SessionLock) refreshBgTask(ctx context.Context) <-chan struct{} {
 			return
 		}
 
-		err := s.Lock.Refresh(ctx, s.lockTTL, nil)
+		err := s.lock.Refresh(ctx, s.lockTTL)
 		if err == nil || c.Done() || s.isReleased.True() {
 			return
 		}
 
 		s.isReleased.Set(true)
-		s.logger.Error(`锁续约失败`, zap.String(`lock`, s.Lock.Key()), zap.String(`token`, s.Lock.Token()), zap.Error(err))
+		s.logger.Error(`锁续约失败`, zap.String(`lock`, s.Key()), zap.String(`token`, s.Token()), zap.Error(err))
 		c.Abort()
 
 		if s.onLockRefreshFailed != nil {
-			s.onLockRefreshFailed(s.Lock.Key())
+			s.onLockRefreshFailed(s.Key())
 		}
 
 	})
@@ -0,0 +1,378 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bsm/redislock"
+	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"time"
+)
+
+const (
+	lockModeRead  = `read`
+	lockModeWrite = `write`
+)
+
+// 以下锁的key均使用hash tag，以便在redis集群下多个key位于同1个slot
+// 读写锁(可重入锁)：{name}为hash，字段mode为锁模式，其他字段为持有者及其持有次数；{name}:lease为zset，保存持有者的租约到期时间
+// 公平锁：{name}为string，值为持有者；{name}:queue为zset，保存等待者排队时间；{name}:timeout为zset，保存等待者的等待到期时间
+var (
+	//清除租约已到期的持有者，ARGV[3]为当前时间戳(毫秒)
+	rwLockCleanScript = `
+	local now = tonumber(ARGV[3])
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+	for _, o in ipairs(expired) do
+		redis.call('HDEL', KEYS[1], o)
+		redis.call('ZREM', KEYS[2], o)
+	end
+	if redis.call('HLEN', KEYS[1]) <= 1 then
+		redis.call('DEL', KEYS[1], KEYS[2])
+	end
+	`
+
+	//设置key的ttl为最晚到期的租约
+	rwLockExpireScript = `
+	local last = redis.call('ZRANGE', KEYS[2], -1, -1, 'WITHSCORES')
+	if last[2] then
+		local ttl = math.max(tonumber(last[2]) - now, 1)
+		redis.call('PEXPIRE', KEYS[1], ttl)
+		redis.call('PEXPIRE', KEYS[2], ttl)
+	end
+	`
+
+	//获取读写锁，ARGV：owner,ttl,now,mode。成功返回持有次数，失败返回0
+	//读锁可被多个持有者同时持有，写锁仅可被1个持有者持有。同1持有者可重入，但不能同时持有读锁和写锁
+	rwLockObtainScript = redis.NewScript(rwLockCleanScript + `
+	local mode = redis.call('HGET', KEYS[1], 'mode')
+	if mode == false or mode == ARGV[4] and (mode == 'read' or redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1) then
+		redis.call('HSET', KEYS[1], 'mode', ARGV[4])
+		local n = redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+		redis.call('ZADD', KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+		` + rwLockExpireScript + `
+		return n
+	end
+	return 0
+    `)
+
+	//续约读写锁，ARGV：owner,ttl,now。成功返回1
+	rwLockRefreshScript = redis.NewScript(rwLockCleanScript + `
+	if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+		return 0
+	end
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+	` + rwLockExpireScript + `
+	return 1
+    `)
+
+	//释放读写锁(持有次数-1)，ARGV：owner,ttl,now。返回剩余持有次数，未持有锁返回-1
+	rwLockReleaseScript = redis.NewScript(rwLockCleanScript + `
+	if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+		return -1
+	end
+	local n = redis.call('HINCRBY', KEYS[1], ARGV[1], -1)
+	if n <= 0 then
+		redis.call('HDEL', KEYS[1], ARGV[1])
+		redis.call('ZREM', KEYS[2], ARGV[1])
+		if redis.call('HLEN', KEYS[1]) <= 1 then
+			redis.call('DEL', KEYS[1], KEYS[2])
+		end
+		return 0
+	end
+	return n
+    `)
+
+	//获取公平锁，ARGV：owner,ttl,now,waitTTL。成功返回1
+	//如果锁未被持有且排在队首则获取锁，否则加入等待队列。等待者应在waitTTL内重试，否则将被移出队列
+	fairLockObtainScript = redis.NewScript(`
+	local now = tonumber(ARGV[3])
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now)
+	for _, o in ipairs(expired) do
+		redis.call('ZREM', KEYS[2], o)
+		redis.call('ZREM', KEYS[3], o)
+	end
+
+	local head = redis.call('ZRANGE', KEYS[2], 0, 0)[1]
+	if redis.call('EXISTS', KEYS[1]) == 0 and (head == nil or head == ARGV[1]) then
+		redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		redis.call('ZREM', KEYS[2], ARGV[1])
+		redis.call('ZREM', KEYS[3], ARGV[1])
+		return 1
+	end
+
+	if tonumber(ARGV[4]) > 0 then
+		redis.call('ZADD', KEYS[2], 'NX', now, ARGV[1])
+		redis.call('ZADD', KEYS[3], now + tonumber(ARGV[4]), ARGV[1])
+		local last = redis.call('ZRANGE', KEYS[3], -1, -1, 'WITHSCORES')
+		local ttl = math.max(tonumber(last[2]) - now, 1)
+		redis.call('PEXPIRE', KEYS[2], ttl)
+		redis.call('PEXPIRE', KEYS[3], ttl)
+	end
+	return 0
+    `)
+
+	//退出公平锁等待队列，ARGV：owner
+	fairLockCancelScript = redis.NewScript(`
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return 1
+    `)
+
+	//获取锁并生成防护令牌，KEYS：lockName,fencingKey，ARGV：owner,ttl。成功返回防护令牌，失败返回0
+	fencedLockObtainScript = redis.NewScript(`
+	if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+		return redis.call('INCR', KEYS[2])
+	end
+	return 0
+    `)
+
+	//续约锁(值为持有者)，ARGV：owner,ttl。成功返回1
+	tokenLockRefreshScript = redis.NewScript(`
+	if redis.call('GET', KEYS[1]) == ARGV[1] then
+		return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	end
+	return 0
+    `)
+
+	//查询锁剩余有效时长(毫秒)，ARGV：owner,now。未持有锁返回0
+	//string的值为持有者，hash(读写锁)的持有者租约保存在KEYS[2]，zset(信号量)的score为持有者租约到期时间
+	scriptLockTTLScript = redis.NewScript(`
+	local now = tonumber(ARGV[2])
+	local t = redis.call('TYPE', KEYS[1]).ok
+	local expireAt
+	if t == 'string' then
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			return redis.call('PTTL', KEYS[1])
+		end
+	elseif t == 'hash' then
+		if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+			expireAt = redis.call('ZSCORE', KEYS[2], ARGV[1])
+		end
+	elseif t == 'zset' then
+		expireAt = redis.call('ZSCORE', KEYS[1], ARGV[1])
+	end
+	if expireAt then
+		return math.max(tonumber(expireAt) - now, 0)
+	end
+	return 0
+    `)
+
+	//释放锁(值为持有者)，ARGV：owner。成功返回1
+	tokenLockReleaseScript = redis.NewScript(`
+	if redis.call('GET', KEYS[1]) == ARGV[1] then
+		return redis.call('DEL', KEYS[1])
+	end
+	return -1
+    `)
+)
+
+// 基于lua脚本实现的锁
+type scriptLockHandle struct {
+	client        redis.UniversalClient
+	keys          []string
+	owner         string
+	refreshScript *redis.Script
+	releaseScript *redis.Script
+}
+
+func (h *scriptLockHandle) Key() string {
+	return h.keys[0]
+}
+
+func (h *scriptLockHandle) Token() string {
+	return h.owner
+}
+
+func (h *scriptLockHandle) Metadata() string {
+	return ``
+}
+
+func (h *scriptLockHandle) TTL(ctx context.Context) (time.Duration, error) {
+	ms, err := scriptLockTTLScript.Run(ctx, h.client, h.keys, h.owner, time.Now().UnixMilli()).Int64()
+	if err != nil || ms <= 0 {
+		return 0, err
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (h *scriptLockHandle) Refresh(ctx context.Context, ttl time.Duration) error {
+	ok, err := h.refreshScript.Run(ctx, h.client, h.keys, h.owner, ttl.Milliseconds(), time.Now().UnixMilli()).Bool()
+	if err == nil && !ok {
+		err = redislock.ErrNotObtained
+	}
+
+	return err
+}
+
+func (h *scriptLockHandle) Release(ctx context.Context) error {
+	n, err := h.releaseScript.Run(ctx, h.client, h.keys, h.owner, 0, time.Now().UnixMilli()).Int64()
+	if err == nil && n < 0 {
+		err = redislock.ErrLockNotHeld
+	}
+
+	return err
+}
+
+func newOwnerIfEmpty(owner string) string {
+	if owner == `` {
+		return shortuuid.New()
+	}
+
+	return owner
+}
+
+// ObtainSessionLockWithOwner 获取会话锁，锁的值为owner，可通过GET lockName查询当前持有者
+// owner应唯一标识持有者，为空则随机生成。锁的key与 redislock 相同，可与其他会话锁互斥
+func (l *Locker) ObtainSessionLockWithOwner(ctx context.Context, lockName, owner string, lockTTL time.Duration) (*SessionLock, error) {
+	h := &scriptLockHandle{
+		client:        l.client,
+		keys:          []string{lockName},
+		owner:         newOwnerIfEmpty(owner),
+		refreshScript: tokenLockRefreshScript,
+		releaseScript: tokenLockReleaseScript,
+	}
+
+	if l.enableFencingToken {
+		return l.obtainFencedSessionLock(ctx, h, lockTTL)
+	}
+
+	lock, err := l.obtainLock(ctx, lockTTL, func() (lockHandle, error) {
+		ok, err := l.client.SetNX(ctx, lockName, h.owner, lockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, redislock.ErrNotObtained
+		}
+
+		return h, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSessionLock(lock, lockTTL, l.onLockRefreshFailedFn), nil
+}
+
+// 获取带防护令牌的会话锁
+func (l *Locker) obtainFencedSessionLock(ctx context.Context, h *scriptLockHandle, lockTTL time.Duration) (*SessionLock, error) {
+	lockName := h.Key()
+
+	var token int64
+	lock, err := l.obtainLock(ctx, lockTTL, func() (lockHandle, error) {
+		n, err := fencedLockObtainScript.Run(ctx, l.client, []string{lockName, FencingTokenKey(lockName)}, h.owner, lockTTL.Milliseconds()).Int64()
+		if err != nil {
+			return nil, err
+		}
+
+		if n <= 0 {
+			return nil, redislock.ErrNotObtained
+		}
+
+		token = n
+		return h, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSessionLock(lock, lockTTL, l.onLockRefreshFailedFn)
+	s.fencingToken = token
+	return s, nil
+}
+
+// FencingTokenKey 锁对应的防护令牌计数器key
+func FencingTokenKey(lockName string) string {
+	return lockName + `:fencing`
+}
+
+func (l *Locker) obtainRWLock(ctx context.Context, lockName, owner, mode string, lockTTL time.Duration) (*SessionLock, error) {
+	h := &scriptLockHandle{
+		client:        l.client,
+		keys:          []string{`{` + lockName + `}`, `{` + lockName + `}:lease`},
+		owner:         newOwnerIfEmpty(owner),
+		refreshScript: rwLockRefreshScript,
+		releaseScript: rwLockReleaseScript,
+	}
+
+	lock, err := l.obtainLock(ctx, lockTTL, func() (lockHandle, error) {
+		n, err := rwLockObtainScript.Run(ctx, l.client, h.keys, h.owner, lockTTL.Milliseconds(), time.Now().UnixMilli(), mode).Int64()
+		if err != nil {
+			return nil, err
+		}
+
+		if n <= 0 {
+			return nil, redislock.ErrNotObtained
+		}
+
+		return h, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSessionLock(lock, lockTTL, l.onLockRefreshFailedFn), nil
+}
+
+// ObtainReentrantLock 获取可重入会话锁，参数owner为持有者标识
+// 同1持有者可多次获取锁，每次获取锁持有次数+1，每次释放锁持有次数-1，持有次数为0时锁被释放
+func (l *Locker) ObtainReentrantLock(ctx context.Context, lockName, owner string, lockTTL time.Duration) (*SessionLock, error) {
+	return l.obtainRWLock(ctx, lockName, owner, lockModeWrite, lockTTL)
+}
+
+// ObtainReadLock 获取读会话锁，可被多个持有者同时持有，与写锁互斥。参数owner为空则随机生成
+func (l *Locker) ObtainReadLock(ctx context.Context, lockName, owner string, lockTTL time.Duration) (*SessionLock, error) {
+	return l.obtainRWLock(ctx, lockName, owner, lockModeRead, lockTTL)
+}
+
+// ObtainWriteLock 获取写会话锁，仅可被1个持有者持有(可重入)，与读锁互斥。参数owner为空则随机生成
+func (l *Locker) ObtainWriteLock(ctx context.Context, lockName, owner string, lockTTL time.Duration) (*SessionLock, error) {
+	return l.obtainRWLock(ctx, lockName, owner, lockModeWrite, lockTTL)
+}
+
+// ObtainFairLock 获取公平会话锁，等待者按先后顺序获取锁
+// 需调用 WithRetryOption 设置重试，否则获取锁失败将不会排队。等待者如超过3倍重试间隔时长未重试，则将被移出等待队列
+func (l *Locker) ObtainFairLock(ctx context.Context, lockName string, lockTTL time.Duration) (*SessionLock, error) {
+	h := &scriptLockHandle{
+		client:        l.client,
+		keys:          []string{`{` + lockName + `}`, `{` + lockName + `}:queue`, `{` + lockName + `}:timeout`},
+		owner:         shortuuid.New(),
+		refreshScript: tokenLockRefreshScript,
+		releaseScript: tokenLockReleaseScript,
+	}
+
+	var waitTTL time.Duration
+	if l.retryLimit > 0 {
+		waitTTL = 3 * l.retryInterval
+		if waitTTL <= 0 {
+			waitTTL = 3 * lockTTL
+		}
+	}
+
+	lock, err := l.obtainLock(ctx, lockTTL, func() (lockHandle, error) {
+		ok, err := fairLockObtainScript.Run(ctx, l.client, h.keys, h.owner, lockTTL.Milliseconds(), time.Now().UnixMilli(), waitTTL.Milliseconds()).Bool()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, redislock.ErrNotObtained
+		}
+
+		return h, nil
+	})
+
+	if err != nil {
+		if waitTTL > 0 {
+			_ = fairLockCancelScript.Run(context.Background(), l.client, h.keys, h.owner).Err()
+		}
+
+		return nil, err
+	}
+
+	return newSessionLock(lock, lockTTL, l.onLockRefreshFailedFn), nil
+}
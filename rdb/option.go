@@ -0,0 +1,159 @@
+package rdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	ModeStandalone = `standalone` //单机
+	ModeSentinel   = `sentinel`   //哨兵
+	ModeCluster    = `cluster`    //集群
+)
+
+// 连接池配置，详情参考redis.Options
+type PoolOption struct {
+	PoolSize     int           //最大连接数，默认每个CPU 10个连接
+	MinIdleConns int           //最小空闲连接数
+	MaxConnAge   time.Duration //连接最大生存时间，默认不关闭
+	PoolTimeout  time.Duration //等待获取连接超时时长，默认ReadTimeout+1s
+	IdleTimeout  time.Duration //连接最大空闲时间，默认5m
+}
+
+// TLS配置，Enabled为false则不启用
+type TLSOption struct {
+	Enabled            bool
+	CertFile           string //客户端证书文件
+	KeyFile            string //客户端私钥文件
+	CAFile             string //CA证书文件，为空则使用系统CA
+	ServerName         string //服务端名称
+	InsecureSkipVerify bool   //是否不校验服务端证书
+}
+
+func (o *TLSOption) MustNewConfig() *tls.Config {
+	if o == nil || !o.Enabled {
+		return nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if !_string.Empty(o.CertFile) || !_string.Empty(o.KeyFile) {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		util.AssertNilErr(err, `读取客户端证书出错`)
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if !_string.Empty(o.CAFile) {
+		data, err := ioutil.ReadFile(o.CAFile)
+		util.AssertNilErr(err, `读取CA证书出错`)
+
+		pool := x509.NewCertPool()
+		util.AssertOk(pool.AppendCertsFromPEM(data), `无效CA证书[%v]`, o.CAFile)
+		cfg.RootCAs = pool
+	}
+
+	return cfg
+}
+
+// 日志选项
+type LoggerOption struct {
+	SlowThreshold time.Duration //慢命令耗时临界值，默认100ms，小于0则不输出慢命令日志
+	LogError      bool          //是否输出命令出错日志，不包括redis.Nil
+}
+
+// Option redis客户端配置
+// Mode为空时：MasterName不为空则为哨兵模式，Addrs数量大于1则为集群模式，否则为单机模式
+type Option struct {
+	Mode       string   //模式：standalone/sentinel/cluster
+	Addrs      []string //服务器地址，哨兵模式为哨兵地址，集群模式为种子节点地址，默认localhost:6379
+	MasterName string   //哨兵模式主节点名称
+	Username   string
+	Password   string
+	DB         int //数据库，集群模式忽略
+
+	SentinelPassword string //哨兵密码
+
+	MaxRetries   int           //命令失败最大重试次数，默认3，-1不重试
+	DialTimeout  time.Duration //连接超时时长，默认5s
+	ReadTimeout  time.Duration //读超时时长，默认3s
+	WriteTimeout time.Duration //写超时时长，默认同ReadTimeout
+
+	ReadOnly       bool //集群/哨兵模式是否允许从节点读
+	RouteByLatency bool //集群模式是否路由到延迟最低的节点
+	RouteRandomly  bool //集群模式是否随机路由
+
+	Pool PoolOption
+	TLS  *TLSOption
+	Log  LoggerOption
+}
+
+func (o *Option) MustNormalize() *Option {
+	util.AssertOk(o != nil, `option为空`)
+
+	if len(o.Addrs) == 0 {
+		o.Addrs = []string{`localhost:6379`}
+	}
+
+	if _string.Empty(o.Mode) {
+		switch {
+		case !_string.Empty(o.MasterName):
+			o.Mode = ModeSentinel
+		case len(o.Addrs) > 1:
+			o.Mode = ModeCluster
+		default:
+			o.Mode = ModeStandalone
+		}
+	}
+
+	o.Mode = strings.ToLower(o.Mode)
+	switch o.Mode {
+	case ModeStandalone:
+		util.AssertOk(len(o.Addrs) == 1, `单机模式Addrs只能有1个地址`)
+	case ModeSentinel:
+		util.AssertNotEmpty(o.MasterName, `MasterName为空`)
+	case ModeCluster:
+	default:
+		util.AssertOk(false, `无效Mode[%v]`, o.Mode)
+	}
+
+	if o.Log.SlowThreshold == 0 {
+		o.Log.SlowThreshold = 100 * time.Millisecond
+	}
+
+	return o
+}
+
+// UniversalOptions 转换为go-redis配置，未设置的值使用go-redis默认值
+func (o *Option) UniversalOptions() *redis.UniversalOptions {
+	return &redis.UniversalOptions{
+		Addrs:            o.Addrs,
+		MasterName:       o.MasterName,
+		Username:         o.Username,
+		Password:         o.Password,
+		DB:               o.DB,
+		SentinelPassword: o.SentinelPassword,
+		MaxRetries:       o.MaxRetries,
+		DialTimeout:      o.DialTimeout,
+		ReadTimeout:      o.ReadTimeout,
+		WriteTimeout:     o.WriteTimeout,
+		ReadOnly:         o.ReadOnly,
+		RouteByLatency:   o.RouteByLatency,
+		RouteRandomly:    o.RouteRandomly,
+		PoolSize:         o.Pool.PoolSize,
+		MinIdleConns:     o.Pool.MinIdleConns,
+		MaxConnAge:       o.Pool.MaxConnAge,
+		PoolTimeout:      o.Pool.PoolTimeout,
+		IdleTimeout:      o.Pool.IdleTimeout,
+		TLSConfig:        o.TLS.MustNewConfig(),
+	}
+}
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"github.com/bingooh/b-go-util/rdb"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 	"time"
@@ -13,13 +14,19 @@ type Context interface {
 	Option() *TaskOption
 	GetTaskNextInvokeTime() (time.Time, error)
 	SetTaskNextInvokeTime(tm time.Time) error
+	ScheduledTime() time.Time //Cron任务本次执行对应的cron执行时间，非Cron任务为零值
+	ShardIndex() int          //本次执行的分片序号，从0开始，非分片任务为0
+	ShardCount() int          //分片数，非分片任务为1
 }
 
 type BaseContext struct {
-	ctx    context.Context
-	client *redis.Client
-	logger *zap.Logger
-	option *TaskOption
+	ctx           context.Context
+	client        redis.UniversalClient
+	logger        *zap.Logger
+	option        *TaskOption
+	scheduledTime time.Time
+	shardIndex    int
+	shardCount    int
 }
 
 var _ Context = (*BaseContext)(nil)
@@ -36,6 +43,40 @@ func (c *BaseContext) Option() *TaskOption {
 	return c.option
 }
 
+func (c *BaseContext) ScheduledTime() time.Time {
+	return c.scheduledTime
+}
+
+func (c *BaseContext) ShardIndex() int {
+	return c.shardIndex
+}
+
+func (c *BaseContext) ShardCount() int {
+	if c.shardCount <= 0 {
+		return 1
+	}
+
+	return c.shardCount
+}
+
+func (c *BaseContext) withShard(index, count int) *BaseContext {
+	nc := *c
+	nc.shardIndex, nc.shardCount = index, count
+	return &nc
+}
+
+func (c *BaseContext) withContext(ctx context.Context) *BaseContext {
+	nc := *c
+	nc.ctx = ctx
+	return &nc
+}
+
+func (c *BaseContext) withScheduledTime(t time.Time) *BaseContext {
+	nc := *c
+	nc.scheduledTime = t
+	return &nc
+}
+
 func (c *BaseContext) GetTaskNextInvokeTime() (time.Time, error) {
 	v, err := c.client.Get(c.ctx, c.option.TaskInvokeTimeKey()).Int64()
 	if err != nil {
@@ -46,7 +87,7 @@ func (c *BaseContext) GetTaskNextInvokeTime() (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	return time.Unix(v, 0), nil
+	return time.Unix(v, 0).In(c.option.location), nil
 }
 
 func (c *BaseContext) SetTaskNextInvokeTime(tm time.Time) error {
@@ -56,3 +97,31 @@ func (c *BaseContext) SetTaskNextInvokeTime(tm time.Time) error {
 
 	return c.client.Set(c.ctx, c.option.TaskInvokeTimeKey(), tm.Unix(), 0).Err()
 }
+
+// 下次执行时间是否已到，Cron任务首次调度时保存下次执行时间
+func (c *BaseContext) isTaskNextInvokeTimeUp(now time.Time) (bool, error) {
+	v, err := c.GetTaskNextInvokeTime()
+	if err != nil {
+		return false, err
+	}
+
+	if v.IsZero() {
+		if c.option.IsCron() {
+			return false, c.initTaskNextInvokeTime(c.option.NextInvokeTime(now))
+		}
+
+		return true, nil
+	}
+
+	return !now.Before(v), nil
+}
+
+// 下次执行时间不存在则设置，用于Cron任务首次调度
+func (c *BaseContext) initTaskNextInvokeTime(tm time.Time) error {
+	return c.client.SetNX(c.ctx, c.option.TaskInvokeTimeKey(), tm.Unix(), 0).Err()
+}
+
+// 下次执行时间仍为expect则设置为tm，返回false表示已被其他调度器修改
+func (c *BaseContext) casTaskNextInvokeTime(expect, tm time.Time) (bool, error) {
+	return rdb.SetEQ(c.ctx, c.client, c.option.TaskInvokeTimeKey(), expect.Unix(), tm.Unix())
+}
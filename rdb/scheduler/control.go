@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 控制命令保存在redis，各调度器节点下次检查任务时生效，同时通过pub/sub通知各节点立即检查任务
+
+// TriggerNow 立即执行1次任务，由任意1个调度器节点执行。不受暂停、执行时间区间和下次执行时间限制，不修改下次执行时间
+func (s *Scheduler) TriggerNow(ctx context.Context, name string) error {
+	o := s.mustGetTaskOption(name)
+	if err := s.mustGetClient().Set(ctx, o.TaskTriggerKey(), time.Now().Unix(), 0).Err(); err != nil {
+		return err
+	}
+
+	return s.notify(ctx, name)
+}
+
+// Pause 暂停任务，暂停期间不会定时执行，可调用TriggerNow()手动执行
+func (s *Scheduler) Pause(ctx context.Context, name string) error {
+	o := s.mustGetTaskOption(name)
+	if err := s.mustGetClient().Set(ctx, o.TaskPauseKey(), time.Now().Unix(), 0).Err(); err != nil {
+		return err
+	}
+
+	return s.notify(ctx, name)
+}
+
+// Resume 恢复已暂停的任务
+func (s *Scheduler) Resume(ctx context.Context, name string) error {
+	o := s.mustGetTaskOption(name)
+	if err := s.mustGetClient().Del(ctx, o.TaskPauseKey()).Err(); err != nil {
+		return err
+	}
+
+	return s.notify(ctx, name)
+}
+
+// IsPaused 任务是否已暂停
+func (s *Scheduler) IsPaused(ctx context.Context, name string) (bool, error) {
+	o := s.mustGetTaskOption(name)
+	n, err := s.mustGetClient().Exists(ctx, o.TaskPauseKey()).Result()
+	return n > 0, err
+}
+
+// SetNextInvokeTime 设置任务下次执行时间。参数t为零值则删除下次执行时间：非Cron任务将立即执行，Cron任务将重新计算下次执行时间
+func (s *Scheduler) SetNextInvokeTime(ctx context.Context, name string, t time.Time) error {
+	o := s.mustGetTaskOption(name)
+	taskCtx := &BaseContext{ctx: ctx, client: s.mustGetClient(), option: o}
+	if err := taskCtx.SetTaskNextInvokeTime(t); err != nil {
+		return err
+	}
+
+	return s.notify(ctx, name)
+}
+
+func (s *Scheduler) notify(ctx context.Context, name string) error {
+	return s.client.Publish(ctx, s.option.ControlChannel(), name).Err()
+}
+
+// 订阅控制通知，收到通知后唤醒对应任务
+func (s *Scheduler) subscribe() {
+	ctx := s.loopCtx.Context()
+	s.pubsub = s.client.Subscribe(ctx, s.option.ControlChannel())
+
+	go func() {
+		for msg := range s.pubsub.Channel() {
+			s.lock.RLock()
+			state, ok := s.states[msg.Payload]
+			s.lock.RUnlock()
+
+			if ok {
+				select {
+				case state.wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) unsubscribe() {
+	if s.pubsub == nil {
+		return
+	}
+
+	if err := s.pubsub.Close(); err != nil {
+		s.logger.Error(`取消订阅控制通知出错`, zap.Error(err))
+	}
+}
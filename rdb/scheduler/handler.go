@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	bhttp "github.com/bingooh/b-go-util/http"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterHandlers 注册任务管理接口，如：s.RegisterHandlers(router.Group(`/admin/scheduler`))
+//
+//	GET  /tasks                      查询全部任务状态
+//	GET  /tasks/:name                查询任务状态
+//	GET  /tasks/:name/history        查询任务执行记录，查询参数：offset默认0，count默认20
+//	POST /tasks/:name/trigger        立即执行1次任务
+//	POST /tasks/:name/pause          暂停任务
+//	POST /tasks/:name/resume         恢复任务
+//	PUT  /tasks/:name/next-invoke-time 设置下次执行时间，查询参数time：RFC3339格式或unix秒，为空则删除
+func (s *Scheduler) RegisterHandlers(r gin.IRouter) {
+	r.GET(`/tasks`, s.handleListTaskStatus)
+	r.GET(`/tasks/:name`, s.withTask(s.handleTaskStatus))
+	r.GET(`/tasks/:name/history`, s.withTask(s.handleTaskHistory))
+	r.POST(`/tasks/:name/trigger`, s.withTask(s.handleTriggerNow))
+	r.POST(`/tasks/:name/pause`, s.withTask(s.handlePause))
+	r.POST(`/tasks/:name/resume`, s.withTask(s.handleResume))
+	r.PUT(`/tasks/:name/next-invoke-time`, s.withTask(s.handleSetNextInvokeTime))
+}
+
+func abortWithError(c *gin.Context, err *bhttp.Error) {
+	c.AbortWithStatusJSON(err.Status(), err)
+}
+
+// 检查任务是否存在
+func (s *Scheduler) withTask(fn func(c *gin.Context, name string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param(`name`)
+		if _, ok := s.getTaskOption(name); !ok {
+			abortWithError(c, bhttp.NewError(http.StatusNotFound, util.ErrCodeNotFound, `任务不存在[%v]`, name))
+			return
+		}
+
+		fn(c, name)
+	}
+}
+
+func (s *Scheduler) handleListTaskStatus(c *gin.Context) {
+	rs, err := s.ListTaskStatus(c.Request.Context())
+	if err != nil {
+		abortWithError(c, bhttp.New500Error(util.ErrCodeRedis, err, `查询任务状态出错`))
+		return
+	}
+
+	c.JSON(http.StatusOK, rs)
+}
+
+func (s *Scheduler) handleTaskStatus(c *gin.Context, name string) {
+	rs, err := s.TaskStatus(c.Request.Context(), name)
+	if err != nil {
+		abortWithError(c, bhttp.New500Error(util.ErrCodeRedis, err, `查询任务状态出错`))
+		return
+	}
+
+	c.JSON(http.StatusOK, rs)
+}
+
+func (s *Scheduler) handleTaskHistory(c *gin.Context, name string) {
+	offset, err1 := strconv.ParseInt(c.DefaultQuery(`offset`, `0`), 10, 64)
+	count, err2 := strconv.ParseInt(c.DefaultQuery(`count`, `20`), 10, 64)
+	if err1 != nil || err2 != nil || offset < 0 || count <= 0 {
+		abortWithError(c, bhttp.New400Error(util.ErrCodeIllegalArg, `无效offset或count`))
+		return
+	}
+
+	rs, err := s.TaskHistory(c.Request.Context(), name, offset, count)
+	if err != nil {
+		abortWithError(c, bhttp.New500Error(util.ErrCodeRedis, err, `查询任务执行记录出错`))
+		return
+	}
+
+	c.JSON(http.StatusOK, rs)
+}
+
+func (s *Scheduler) handleTriggerNow(c *gin.Context, name string) {
+	s.handleControl(c, s.TriggerNow(c.Request.Context(), name))
+}
+
+func (s *Scheduler) handlePause(c *gin.Context, name string) {
+	s.handleControl(c, s.Pause(c.Request.Context(), name))
+}
+
+func (s *Scheduler) handleResume(c *gin.Context, name string) {
+	s.handleControl(c, s.Resume(c.Request.Context(), name))
+}
+
+func (s *Scheduler) handleSetNextInvokeTime(c *gin.Context, name string) {
+	var t time.Time
+	if v := c.Query(`time`); v != `` {
+		if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+			t = time.Unix(sec, 0)
+		} else if t, err = time.Parse(time.RFC3339, v); err != nil {
+			abortWithError(c, bhttp.New400Error(util.ErrCodeIllegalArg, err, `无效time`))
+			return
+		}
+	}
+
+	s.handleControl(c, s.SetNextInvokeTime(c.Request.Context(), name, t))
+}
+
+func (s *Scheduler) handleControl(c *gin.Context, err error) {
+	if err != nil {
+		abortWithError(c, bhttp.New500Error(util.ErrCodeRedis, err, `任务控制出错`))
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
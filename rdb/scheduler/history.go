@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+)
+
+// TaskRun 任务执行记录
+type TaskRun struct {
+	Task          string        `json:"task"`
+	Node          string        `json:"node"`                     //执行任务的调度器节点
+	ScheduledTime time.Time     `json:"scheduled_time,omitempty"` //Cron任务对应的cron执行时间
+	StartTime     time.Time     `json:"start_time"`
+	EndTime       time.Time     `json:"end_time"`
+	Duration      time.Duration `json:"duration"`
+	CatchUpIndex  int           `json:"catch_up_index"`        //本次检查执行的第几个执行时间，从1开始，Cron任务补执行多个错过的执行时间时大于1
+	ShardIndex    int           `json:"shard_index,omitempty"` //分片序号，仅分片任务有效
+	ShardCount    int           `json:"shard_count,omitempty"` //分片数，非分片任务为0
+	Error         string        `json:"error,omitempty"`
+}
+
+func (r *TaskRun) Success() bool {
+	return r.Error == ``
+}
+
+// TaskStatus 任务状态
+type TaskStatus struct {
+	Name           string    `json:"name"`
+	Disabled       bool      `json:"disabled"`
+	Paused         bool      `json:"paused"`
+	Cron           string    `json:"cron,omitempty"`
+	NextInvokeTime time.Time `json:"next_invoke_time"`
+	SuccessCount   int64     `json:"success_count"`
+	FailureCount   int64     `json:"failure_count"`
+	LastSuccess    *TaskRun  `json:"last_success,omitempty"`
+	LastFailure    *TaskRun  `json:"last_failure,omitempty"`
+}
+
+const (
+	statusFieldSuccessCount = `success_count`
+	statusFieldFailureCount = `failure_count`
+	statusFieldLastSuccess  = `last_success`
+	statusFieldLastFailure  = `last_failure`
+)
+
+// 保存执行记录，更新最近成功/失败记录和计数
+func (s *Scheduler) saveTaskRun(ctx context.Context, o *TaskOption, run *TaskRun) error {
+	if s.option.HistorySize < 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	countField, lastField := statusFieldSuccessCount, statusFieldLastSuccess
+	if !run.Success() {
+		countField, lastField = statusFieldFailureCount, statusFieldLastFailure
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.LPush(ctx, o.TaskHistoryKey(), data)
+		p.LTrim(ctx, o.TaskHistoryKey(), 0, int64(s.option.HistorySize-1))
+		p.HIncrBy(ctx, o.TaskStatusKey(), countField, 1)
+		p.HSet(ctx, o.TaskStatusKey(), lastField, data)
+		return nil
+	})
+
+	return err
+}
+
+func (s *Scheduler) mustGetClient() redis.UniversalClient {
+	util.AssertOk(s.client != nil, `redis客户端为空，请先调用Start()或WithClient()`)
+	return s.client
+}
+
+// TaskStatus 查询任务状态，任务不存在将崩溃
+func (s *Scheduler) TaskStatus(ctx context.Context, name string) (*TaskStatus, error) {
+	o := s.mustGetTaskOption(name)
+	client := s.mustGetClient()
+
+	var invokeCmd *redis.StringCmd
+	var pauseCmd *redis.IntCmd
+	var statusCmd *redis.StringStringMapCmd
+	_, err := client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		invokeCmd = p.Get(ctx, o.TaskInvokeTimeKey())
+		pauseCmd = p.Exists(ctx, o.TaskPauseKey())
+		statusCmd = p.HGetAll(ctx, o.TaskStatusKey())
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	status := &TaskStatus{Name: name, Disabled: o.Disabled, Paused: pauseCmd.Val() > 0, Cron: o.Cron}
+	if v, err := invokeCmd.Int64(); err == nil {
+		status.NextInvokeTime = time.Unix(v, 0).In(o.location)
+	}
+
+	m := statusCmd.Val()
+	status.SuccessCount, _ = strconv.ParseInt(m[statusFieldSuccessCount], 10, 64)
+	status.FailureCount, _ = strconv.ParseInt(m[statusFieldFailureCount], 10, 64)
+
+	if status.LastSuccess, err = parseTaskRun(m[statusFieldLastSuccess]); err != nil {
+		return nil, err
+	}
+
+	if status.LastFailure, err = parseTaskRun(m[statusFieldLastFailure]); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// ListTaskStatus 查询全部已配置任务的状态，按任务名称排序
+func (s *Scheduler) ListTaskStatus(ctx context.Context) ([]*TaskStatus, error) {
+	names := s.taskNames()
+	rs := make([]*TaskStatus, 0, len(names))
+	for _, name := range names {
+		status, err := s.TaskStatus(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		rs = append(rs, status)
+	}
+
+	return rs, nil
+}
+
+// TaskHistory 查询任务执行记录，按执行时间倒序，参数offset从0开始
+func (s *Scheduler) TaskHistory(ctx context.Context, name string, offset, count int64) ([]*TaskRun, error) {
+	o := s.mustGetTaskOption(name)
+	if count <= 0 {
+		return nil, nil
+	}
+
+	vs, err := s.mustGetClient().LRange(ctx, o.TaskHistoryKey(), offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]*TaskRun, 0, len(vs))
+	for _, v := range vs {
+		run, err := parseTaskRun(v)
+		if err != nil {
+			return nil, err
+		}
+
+		rs = append(rs, run)
+	}
+
+	return rs, nil
+}
+
+func parseTaskRun(data string) (*TaskRun, error) {
+	if data == `` {
+		return nil, nil
+	}
+
+	run := &TaskRun{}
+	if err := json.Unmarshal([]byte(data), run); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
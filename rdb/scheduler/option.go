@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/rdb"
 	"github.com/bingooh/b-go-util/util"
 	"github.com/go-redis/redis/v8"
+	"github.com/robfig/cron/v3"
+	"os"
 	"time"
 )
 
@@ -13,35 +16,88 @@ type TaskOption struct {
 	name      string //任务名称
 	keyPrefix string //任务redis key前缀
 
-	Disabled        bool          //是否禁用
-	DisableTaskLock bool          //是否禁用任务锁
-	TaskLockTTL     time.Duration //任务锁TTL，默认10s
-	InvokeInternal  time.Duration //任务执行间隔时长，默认1m
-	InvokeTimeRange []int         //任务执行时间区间，格式HHMI，如：[1200,1400]
+	location *time.Location
+	schedule cron.Schedule
+	windows  [][2]int
+	weekdays int //允许执行的星期掩码，第n位表示星期n
+
+	Disabled         bool          //是否禁用
+	DisableTaskLock  bool          //是否禁用任务锁
+	TaskLockTTL      time.Duration //任务锁TTL，默认10s
+	Timeout          time.Duration //每次执行超时时长，超时后任务Context.Context()结束，默认不超时
+	InvokeInternal   time.Duration //任务执行间隔时长，默认1m。Cron任务为检查间隔，默认1s
+	InvokeTimeRange  []int         //任务执行时间区间，格式HHMI，如：[1200,1400]
+	InvokeTimeRanges [][]int       //多个任务执行时间区间，与InvokeTimeRange合并
+	Weekdays         []int         //允许执行的星期，0为星期日，为空则不限制
+	TimeZone         string        //时区，如：Asia/Shanghai，默认本地时区。执行时间区间和Cron按此时区计算
+
+	Cron           string //任务触发时间，参考：https://github.com/robfig/cron。设置后下次执行时间由调度器计算并保存
+	Misfire        string //Cron任务错过执行时间的处理策略：skip/once/catchup，默认once
+	MisfireCatchUp int    //catchup策略最多补执行次数，默认1
+
+	Shards int //分片数，大于1则每次执行拆分为多个分片，各分片分别获取分片锁后由任意调度器节点执行，全部分片完成后本次执行才完成。非Cron任务完成后至少间隔InvokeInternal才开始下次执行
 }
 
 func (o *TaskOption) TaskLockKey() string {
 	return fmt.Sprintf(`%v:lock:%v`, o.keyPrefix, o.name)
 }
 
+func (o *TaskOption) TaskShardLockKey(shard int) string {
+	return fmt.Sprintf(`%v:lock:%v:%v`, o.keyPrefix, o.name, shard)
+}
+
+func (o *TaskOption) TaskShardRunKey() string {
+	return fmt.Sprintf(`%v:shard:%v`, o.keyPrefix, o.name)
+}
+
+func (o *TaskOption) TaskShardDoneKey() string {
+	return fmt.Sprintf(`%v:shard:%v:done`, o.keyPrefix, o.name)
+}
+
 func (o *TaskOption) TaskInvokeTimeKey() string {
 	return fmt.Sprintf(`%v:invoke:%v`, o.keyPrefix, o.name)
 }
 
+func (o *TaskOption) TaskPauseKey() string {
+	return fmt.Sprintf(`%v:pause:%v`, o.keyPrefix, o.name)
+}
+
+func (o *TaskOption) TaskTriggerKey() string {
+	return fmt.Sprintf(`%v:trigger:%v`, o.keyPrefix, o.name)
+}
+
+func (o *TaskOption) TaskHistoryKey() string {
+	return fmt.Sprintf(`%v:history:%v`, o.keyPrefix, o.name)
+}
+
+func (o *TaskOption) TaskStatusKey() string {
+	return fmt.Sprintf(`%v:status:%v`, o.keyPrefix, o.name)
+}
+
 func (o *TaskOption) MustNormalize() *TaskOption {
+	return o.mustNormalize(false)
+}
+
+func (o *TaskOption) mustNormalize(enableCronSeconds bool) *TaskOption {
 	util.AssertOk(o != nil, `option为空`)
 	util.AssertNotEmpty(o.name, `name为空`)
 	util.AssertNotEmpty(o.keyPrefix, `keyPrefix为空`)
 
-	n := len(o.InvokeTimeRange)
-	util.AssertOk(n == 0 || n == 2 && o.InvokeTimeRange[0] <= o.InvokeTimeRange[1], `无效InvokeTimeRange[%v]`, o.InvokeTimeRange)
+	o.mustParseSchedule(enableCronSeconds)
+
+	util.AssertOk(o.Shards >= 0, `Shards不能小于0`)
+	util.AssertOk(o.Shards <= 1 || !o.DisableTaskLock, `分片任务不能禁用任务锁`)
 
 	if o.TaskLockTTL <= 0 {
 		o.TaskLockTTL = 10 * time.Second
 	}
 
 	if o.InvokeInternal <= 0 {
-		o.InvokeInternal = 1 * time.Minute
+		if o.IsCron() {
+			o.InvokeInternal = 1 * time.Second
+		} else {
+			o.InvokeInternal = 1 * time.Minute
+		}
 	}
 
 	return o
@@ -49,32 +105,56 @@ func (o *TaskOption) MustNormalize() *TaskOption {
 
 type Option struct {
 	rootCtx *util.CancelableContext //由scheduler取消
-	Redis   *redis.Options
-
-	Tasks         map[string]*TaskOption //key为任务名称
-	TaskKeyPrefix string                 //任务redis key前缀，默认task
+	Client  *rdb.Option             //redis客户端配置，优先于Redis
+	Redis   *redis.Options          //Deprecated: 使用Client
+
+	Tasks             map[string]*TaskOption //key为任务名称，可为空，运行时调用Scheduler.MustAddTaskWithOption()添加
+	TaskKeyPrefix     string                 //任务redis key前缀，默认task
+	EnableCronSeconds bool                   //是否启用秒定时设置
+	Node              string                 //当前调度器节点标识，记录在任务执行记录里，默认hostname-pid
+	HistorySize       int                    //每个任务保留的执行记录数，默认100，小于0则不记录执行记录
 }
 
 func (o *Option) MustNormalize() *Option {
 	util.AssertOk(o != nil, `option为空`)
-	util.AssertOk(o.Redis != nil, `Redis为空`)
-	util.AssertOk(len(o.Tasks) > 0, `Tasks为空`)
-
 	o.rootCtx = util.NewCancelableContext()
 	if _string.Empty(o.TaskKeyPrefix) {
 		o.TaskKeyPrefix = `task:`
 	}
 
+	if _string.Empty(o.Node) {
+		host, _ := os.Hostname()
+		o.Node = fmt.Sprintf(`%v-%v`, host, os.Getpid())
+	}
+
+	if o.HistorySize == 0 {
+		o.HistorySize = 100
+	}
+
+	if o.Tasks == nil {
+		o.Tasks = make(map[string]*TaskOption)
+	}
+
 	for name, to := range o.Tasks {
-		util.AssertOk(to != nil, `TaskOption为空`)
-		to.name = name
-		to.keyPrefix = o.TaskKeyPrefix
-		to.MustNormalize()
+		o.mustNormalizeTask(name, to)
 	}
 
 	return o
 }
 
+func (o *Option) mustNormalizeTask(name string, to *TaskOption) *TaskOption {
+	util.AssertNotEmpty(name, `name为空`)
+	util.AssertOk(to != nil, `TaskOption为空`)
+	to.name = name
+	to.keyPrefix = o.TaskKeyPrefix
+	return to.mustNormalize(o.EnableCronSeconds)
+}
+
+// ControlChannel 任务控制通知频道
+func (o *Option) ControlChannel() string {
+	return fmt.Sprintf(`%v:control`, o.TaskKeyPrefix)
+}
+
 func (o *Option) RootContext() context.Context {
 	return o.rootCtx.Context()
 }
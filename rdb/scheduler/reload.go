@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/conf"
+	"github.com/bingooh/b-go-util/util"
+	"go.uber.org/zap"
+)
+
+// 比较任务配置项是否相同，忽略由配置项解析得到的字段
+func (o *TaskOption) equal(other *TaskOption) bool {
+	a, b := *o, *other
+	a.location, a.schedule, a.windows, a.weekdays = nil, nil, nil, 0
+	b.location, b.schedule, b.windows, b.weekdays = nil, nil, nil, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// Reload 重新加载任务配置，逐个任务比较并应用变更，仅应用Tasks，其他配置项变更需重启调度器
+//   - 新增的任务配置需调用MustAddTask()添加任务后才会调度
+//   - 删除的任务配置将停止调度对应任务
+//   - 变更的任务配置将使用新配置重新开始调度，执行中的任务仍使用原配置直到本次执行结束
+//   - Cron或TimeZone变更将删除已保存的下次执行时间，按新配置重新计算
+//   - 调用MustAddTaskWithOption()添加的任务配置保持不变，忽略配置文件里的同名任务配置
+//
+// 任务配置无效返回错误，此时不应用任何变更
+func (s *Scheduler) Reload(option *Option) (err error) {
+	defer util.OnPanic(func(e error) {
+		err = e
+	})
+
+	util.AssertOk(option != nil, `option为空`)
+
+	tasks := make(map[string]*TaskOption, len(option.Tasks))
+	for name, o := range option.Tasks {
+		tasks[name] = s.option.mustNormalizeTask(name, o)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for name := range s.option.Tasks {
+		if _, ok := tasks[name]; !ok && !s.codeTasks[name] {
+			s.stopTask(name)
+			delete(s.option.Tasks, name)
+			s.logger.Sugar().Infof(`删除任务配置[%v]`, name)
+		}
+	}
+
+	for name, o := range tasks {
+		old, ok := s.option.Tasks[name]
+		if ok && (s.codeTasks[name] || old.equal(o)) {
+			continue
+		}
+
+		s.option.Tasks[name] = o
+		s.stopTask(name)
+		if ok && (old.Cron != o.Cron || old.TimeZone != o.TimeZone) {
+			s.resetTaskNextInvokeTime(o)
+		}
+		s.startTask(name)
+
+		if ok {
+			s.logger.Sugar().Infof(`更新任务配置[%v]`, name)
+		} else {
+			s.logger.Sugar().Infof(`新增任务配置[%v]`, name)
+		}
+	}
+
+	return nil
+}
+
+// 删除已保存的下次执行时间，调度器未设置redis客户端则忽略
+func (s *Scheduler) resetTaskNextInvokeTime(o *TaskOption) {
+	if s.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, o.TaskInvokeTimeKey()).Err(); err != nil {
+		s.logger.Error(`任务下次执行时间删除出错`, zap.String(`key`, o.TaskInvokeTimeKey()), zap.Error(err))
+	}
+}
+
+// ReloadCfgFile 读取配置文件重新加载任务配置，参数file与conf.Load()相同，如：scheduler
+func (s *Scheduler) ReloadCfgFile(file string) error {
+	option := &Option{}
+	if err := conf.Load(option, file); err != nil {
+		return err
+	}
+
+	return s.Reload(option)
+}
+
+// WatchCfgFile 每隔interval重新加载配置文件，配置未变更则忽略，调度器停止后结束
+func (s *Scheduler) WatchCfgFile(file string, interval time.Duration) {
+	util.AssertNotEmpty(file, `file为空`)
+	util.AssertOk(interval > 0, `interval必须大于0`)
+
+	async.RunCancelableInterval(s.option.RootContext(), interval, func(c async.Context) {
+		if c.Done() {
+			return
+		}
+
+		if err := s.ReloadCfgFile(file); err != nil {
+			s.logger.Error(`重新加载配置文件出错`, zap.String(`file`, file), zap.Error(err))
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/bingooh/b-go-util/util"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	MisfireSkip    = `skip`    //跳过错过的执行，等待下次执行时间
+	MisfireOnce    = `once`    //立即执行1次
+	MisfireCatchUp = `catchup` //立即补执行最近错过的执行，最多MisfireCatchUp次
+)
+
+// 最多补执行的错过执行次数
+const maxMisfireCount = 1000
+
+func newCronParser(enableSeconds bool) cron.Parser {
+	if enableSeconds {
+		return cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	}
+
+	return cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+}
+
+func (o *TaskOption) mustParseSchedule(enableCronSeconds bool) {
+	o.location = time.Local
+	if o.TimeZone != `` {
+		loc, err := time.LoadLocation(o.TimeZone)
+		util.AssertNilErr(err, `无效TimeZone[%v]`, o.TimeZone)
+		o.location = loc
+	}
+
+	o.windows, o.weekdays, o.schedule = nil, 0, nil
+	ranges := append([][]int{o.InvokeTimeRange}, o.InvokeTimeRanges...)
+	for _, r := range ranges {
+		if len(r) == 0 {
+			continue
+		}
+
+		util.AssertOk(len(r) == 2 && r[0] <= r[1], `无效执行时间区间[%v]`, r)
+		o.windows = append(o.windows, [2]int{r[0], r[1]})
+	}
+
+	for _, d := range o.Weekdays {
+		util.AssertOk(d >= 0 && d <= 6, `无效Weekdays[%v]`, d)
+		o.weekdays |= 1 << d
+	}
+
+	if o.Cron == `` {
+		util.AssertOk(o.Misfire == ``, `Misfire仅适用于Cron任务`)
+		return
+	}
+
+	var err error
+	o.schedule, err = newCronParser(enableCronSeconds).Parse(o.Cron)
+	util.AssertNilErr(err, `无效Cron[%v]`, o.Cron)
+
+	if o.Misfire == `` {
+		o.Misfire = MisfireOnce
+	}
+
+	util.AssertOk(o.Misfire == MisfireSkip || o.Misfire == MisfireOnce || o.Misfire == MisfireCatchUp,
+		`无效Misfire[%v]`, o.Misfire)
+
+	if o.MisfireCatchUp <= 0 {
+		o.MisfireCatchUp = 1
+	}
+}
+
+// Now 任务时区的当前时间
+func (o *TaskOption) Now() time.Time {
+	return time.Now().In(o.location)
+}
+
+// IsCron 是否为Cron任务
+func (o *TaskOption) IsCron() bool {
+	return o.schedule != nil
+}
+
+// NextInvokeTime 参数t之后的下次cron执行时间，非Cron任务返回零值
+func (o *TaskOption) NextInvokeTime(t time.Time) time.Time {
+	if o.schedule == nil {
+		return time.Time{}
+	}
+
+	return o.schedule.Next(t.In(o.location))
+}
+
+// IsInInvokeTime 参数t是否处于执行时间区间和允许执行的星期
+func (o *TaskOption) IsInInvokeTime(t time.Time) bool {
+	t = t.In(o.location)
+	if o.weekdays != 0 && o.weekdays&(1<<t.Weekday()) == 0 {
+		return false
+	}
+
+	if len(o.windows) == 0 {
+		return true
+	}
+
+	hhmi := t.Hour()*100 + t.Minute()
+	for _, w := range o.windows {
+		if w[0] <= hhmi && hhmi <= w[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 根据错过执行策略返回需执行的cron执行时间，参数next为已保存的下次执行时间
+// 执行时间早于now超过InvokeInternal+1s视为错过执行，skip策略仅执行未错过的执行时间
+func (o *TaskOption) dueInvokeTimes(next, now time.Time) []time.Time {
+	n := 1
+	if o.Misfire == MisfireCatchUp {
+		n = o.MisfireCatchUp
+	}
+
+	times := o.lastInvokeTimes(next, now, n)
+	if o.Misfire != MisfireSkip {
+		return times
+	}
+
+	var rs []time.Time
+	for _, t := range times {
+		if now.Sub(t) <= o.InvokeInternal+time.Second {
+			rs = append(rs, t)
+		}
+	}
+
+	return rs
+}
+
+// 返回[from,to]之间最近的n个cron执行时间，从to开始逐步倍增向前查找的时间范围，避免长时间停机后从from开始遍历全部执行时间
+func (o *TaskOption) lastInvokeTimes(from, to time.Time, n int) []time.Time {
+	if n > maxMisfireCount {
+		n = maxMisfireCount
+	}
+
+	for w := time.Minute; ; w *= 2 {
+		start := to.Add(-w)
+		if !start.After(from) {
+			start = from
+		}
+
+		//cron执行时间精确到秒，NextInvokeTime()返回参数之后的执行时间
+		var times []time.Time
+		for t := o.NextInvokeTime(start.Add(-time.Second)); !t.IsZero() && !t.After(to); t = o.NextInvokeTime(t) {
+			if t.Before(start) {
+				continue
+			}
+
+			if times = append(times, t); len(times) > maxMisfireCount {
+				times = times[1:]
+			}
+		}
+
+		if len(times) >= n || start.Equal(from) {
+			if len(times) > n {
+				times = times[len(times)-n:]
+			}
+			return times
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"github.com/bingooh/b-go-util/async"
 	"github.com/bingooh/b-go-util/conf"
 	"github.com/bingooh/b-go-util/rdb"
@@ -8,7 +9,8 @@ import (
 	"github.com/bingooh/b-go-util/util"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
-	"strconv"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -22,6 +24,11 @@ func (f TaskFn) Run(ctx Context) error {
 	return f(ctx)
 }
 
+const (
+	forceStopWait      = 3 * time.Second //强制停止任务后最多等待时长
+	lockReleaseTimeout = 3 * time.Second //任务锁释放超时时长，不使用root ctx，避免调度器停止后无法释放
+)
+
 var DefaultScheduler *Scheduler
 
 func MustGetDefaultScheduler() *Scheduler {
@@ -50,42 +57,118 @@ func MustNewScheduler(option *Option) *Scheduler {
 		logger:    slog.NewLogger(`scheduler`),
 		isRunning: util.NewAtomicBool(false),
 		tasks:     make(map[string]Task),
+		states:    make(map[string]*taskState),
+		codeTasks: make(map[string]bool),
 	}
 }
 
 // Scheduler 任务调度器
 // 每个任务将定时调用，每次调用需要通过以下全部检测才会真正执行任务
+// - 任务未暂停
 // - 当前时间是否处于任务执行时间区间
 // - 当前时间是否大于任务下次执行时间
 // - 启用任务锁且成功获取任务锁(redis分布式锁)
+// 调用TriggerNow()手动触发的任务仅需获取任务锁即可执行
+// 分片任务通过以上检测后开始1次分片执行，各分片获取分片锁后执行，参考TaskOption.Shards
 type Scheduler struct {
 	option *Option
 	logger *zap.Logger
+	lock   sync.RWMutex //保护option.Tasks、tasks和states，任务可在运行时添加、删除和重新加载配置
 
-	client    *redis.Client
+	client    redis.UniversalClient
+	ownClient bool //client是否由scheduler创建，是则停止时关闭
 	isRunning *util.AtomicBool
-	tasks     map[string]Task //key为任务名称
+	tasks     map[string]Task       //key为任务名称
+	states    map[string]*taskState //key为任务名称
+	codeTasks map[string]bool       //由MustAddTaskWithOption()添加任务配置的任务名称，重新加载配置时忽略
+	loopCtx   *util.CancelableContext
+	pubsub    *redis.PubSub
+}
+
+// 任务调度状态
+type taskState struct {
+	mu      sync.Mutex              //定时检查与唤醒检查串行执行，Stop()通过此锁等待执行中的任务
+	wake    chan struct{}           //唤醒任务立即检查
+	running *util.AtomicBool        //是否正在执行
+	loop    *util.CancelableContext //任务定时检查ctx，任务删除或配置变更时取消
+}
+
+func newTaskState() *taskState {
+	return &taskState{
+		wake:    make(chan struct{}, 1),
+		running: util.NewAtomicBool(false),
+	}
+}
+
+// 等待任务当前检查及执行结束
+func (t *taskState) wait() {
+	t.mu.Lock()
+	t.mu.Unlock()
+}
+
+// WithClient 使用外部redis客户端，如：rdb.MustGetDefaultClient()，scheduler停止时不关闭此客户端
+func (s *Scheduler) WithClient(client redis.UniversalClient) *Scheduler {
+	util.AssertOk(s.isRunning.False(), `scheduler已启动`)
+	s.client = client
+	return s
 }
 
 func (s *Scheduler) MustAddTaskFn(name string, task TaskFn) {
 	s.MustAddTask(name, task)
 }
 
-// MustAddTask 添加任务，参数name必须与任务配置项名称相匹配
+// MustAddTask 添加任务，参数name必须与任务配置项名称相匹配。调度器运行时添加的任务将立即开始调度
+// 已禁用的任务也会添加，重新加载配置启用后开始调度
 func (s *Scheduler) MustAddTask(name string, task Task) {
-	util.AssertOk(s.isRunning.False(), `scheduler已启动`)
 	util.AssertNotEmpty(name, `name为空`)
 	util.AssertOk(task != nil, `task为空`)
 
-	if s.option.MustGetTaskOption(name).Disabled {
-		return
-	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.option.MustGetTaskOption(name)
+	s.mustAddTask(name, task)
+}
+
+// MustAddTaskWithOption 添加任务及任务配置，用于添加配置文件里不存在的任务。任务配置已存在则替换
+// 此任务配置不受Reload()影响，调用RemoveTask()删除任务后才会按配置文件重新加载
+func (s *Scheduler) MustAddTaskWithOption(name string, option *TaskOption, task Task) {
+	util.AssertNotEmpty(name, `name为空`)
+	util.AssertOk(task != nil, `task为空`)
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, exist := s.tasks[name]
+	util.AssertOk(!exist, `task已存在[name=%v]`, name)
+
+	s.option.Tasks[name] = s.option.mustNormalizeTask(name, option)
+	s.mustAddTask(name, task)
+	s.codeTasks[name] = true
+}
+
+func (s *Scheduler) mustAddTask(name string, task Task) {
 	_, exist := s.tasks[name]
 	util.AssertOk(!exist, `task已存在[name=%v]`, name)
 
 	s.tasks[name] = task
 	s.logger.Sugar().Infof(`添加任务[%v]`, name)
+	s.startTask(name)
+}
+
+// RemoveTask 删除任务，任务停止调度，执行中的任务继续执行直到本次执行结束。任务配置仍然保留
+func (s *Scheduler) RemoveTask(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.tasks[name]; !ok {
+		return
+	}
+
+	s.stopTask(name)
+	delete(s.tasks, name)
+	delete(s.codeTasks, name)
+	s.logger.Sugar().Infof(`删除任务[%v]`, name)
 }
 
 func (s *Scheduler) Start() {
@@ -93,65 +176,182 @@ func (s *Scheduler) Start() {
 		return
 	}
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
 	if len(s.tasks) == 0 {
-		s.logger.Info(`未启用任何任务`)
+		s.logger.Info(`未添加任何任务`)
+	}
+
+	if s.client == nil {
+		s.client = s.mustNewClient()
+		s.ownClient = true
+	}
+
+	s.loopCtx = util.NewCancelableContextWithParent(s.option.RootContext())
+
+	s.subscribe()
+	for name := range s.tasks {
+		s.startTask(name)
+	}
+}
+
+// 开始任务定时检查，调用方需持有s.lock。调度器未运行、任务未添加或已禁用则忽略
+func (s *Scheduler) startTask(name string) {
+	task, ok := s.tasks[name]
+	o := s.option.Tasks[name]
+	if !ok || o == nil || o.Disabled || s.isRunning.False() || s.loopCtx == nil {
 		return
 	}
 
-	s.client = redis.NewClient(s.option.Redis)
+	//任务删除后重新添加仍使用原任务调度状态，保证同1个任务串行执行
+	state, ok := s.states[name]
+	if !ok {
+		state = newTaskState()
+		s.states[name] = state
+	}
 
-	for name, task := range s.tasks {
-		s.runTask(s.option.MustGetTaskOption(name), task)
+	state.loop = util.NewCancelableContextWithParent(s.loopCtx.Context())
+	s.runTask(o, task, state)
+}
+
+// 停止任务定时检查，调用方需持有s.lock
+func (s *Scheduler) stopTask(name string) {
+	if state, ok := s.states[name]; ok {
+		state.loop.Cancel()
+		state.loop = nil
 	}
 }
 
-func (s *Scheduler) Stop() {
+// Stop 停止调度器，此方法会阻塞直到全部执行中的任务结束或参数ctx结束
+// 参数ctx结束后将取消任务Context.Context()强制停止任务，最多再等待forceStopWait时长，然后关闭redis客户端
+// 参数ctx结束返回ctx.Err()，否则返回nil
+func (s *Scheduler) Stop(ctx context.Context) error {
 	if s == nil || !s.isRunning.CASwap(true) {
-		return
+		return nil
 	}
 
-	s.option.rootCtx.Cancel()
-	time.Sleep(3 * time.Second)
+	//停止定时检查，执行中的任务继续执行
+	s.lock.RLock()
+	s.loopCtx.Cancel()
+	s.lock.RUnlock()
+	s.unsubscribe()
+
+	err := s.waitTasks(ctx)
+	if err != nil {
+		s.logger.Warn(`等待任务执行结束超时，强制停止任务`, zap.Strings(`tasks`, s.runningTasks()))
+		s.option.rootCtx.Cancel()
+
+		waitCtx, cancel := context.WithTimeout(context.Background(), forceStopWait)
+		defer cancel()
+
+		if s.waitTasks(waitCtx) != nil {
+			s.logger.Warn(`强制停止任务超时`, zap.Strings(`tasks`, s.runningTasks()))
+		}
+	}
 
-	if s.client != nil {
+	s.option.rootCtx.Cancel()
+	if s.client != nil && s.ownClient {
 		if err := s.client.Close(); err != nil {
 			s.logger.Error(`redis关闭出错`, zap.Error(err))
 		}
 	}
+
+	s.logger.Info(`scheduler已停止`)
+	return err
 }
 
-func (s *Scheduler) runTask(o *TaskOption, task Task) {
-	ctx := s.option.RootContext()
-	logger := slog.NewLogger(`task`, o.name)
-	taskCtx := &BaseContext{
-		option: o, ctx: ctx,
-		client: s.client, logger: logger,
+// 等待全部任务执行结束
+func (s *Scheduler) waitTasks(ctx context.Context) error {
+	s.lock.RLock()
+	states := make([]*taskState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
 	}
+	s.lock.RUnlock()
 
-	var locker *rdb.Locker
-	if !o.DisableTaskLock {
-		locker = rdb.NewLocker(s.client)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, state := range states {
+			state.wait()
+		}
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	isInInvokeTimeRange := func() bool {
-		if len(o.InvokeTimeRange) == 0 {
-			return true
+// 执行中的任务名称
+func (s *Scheduler) runningTasks() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var names []string
+	for name, state := range s.states {
+		if state.running.True() {
+			names = append(names, name)
 		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// 查询任务配置，任务配置可在运行时重新加载
+func (s *Scheduler) getTaskOption(name string) (*TaskOption, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	o, ok := s.option.Tasks[name]
+	return o, ok
+}
 
-		now, err := strconv.Atoi(time.Now().Format(`1504`))
-		util.AssertNilErr(err, `当前日期转换为HHMI出错`)
+func (s *Scheduler) mustGetTaskOption(name string) *TaskOption {
+	o, ok := s.getTaskOption(name)
+	util.AssertOk(ok, `TaskOption为空[name=%v]`, name)
+	return o
+}
+
+// 全部任务配置名称，按名称排序
+func (s *Scheduler) taskNames() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-		return o.InvokeTimeRange[0] <= now && now <= o.InvokeTimeRange[1]
+	names := make([]string, 0, len(s.option.Tasks))
+	for name := range s.option.Tasks {
+		names = append(names, name)
 	}
 
-	//下次执行任务时间是否已到
-	isTaskNextInvokeTimeUp := func() (bool, error) {
-		v, err := taskCtx.GetTaskNextInvokeTime()
-		if err != nil {
-			return false, err
-		}
+	sort.Strings(names)
+	return names
+}
 
-		return v.IsZero() || time.Since(v) >= 0, nil
+func (s *Scheduler) mustNewClient() redis.UniversalClient {
+	if s.option.Client != nil {
+		return rdb.MustNewClient(s.option.Client)
+	}
+
+	util.AssertOk(s.option.Redis != nil, `Client和Redis全部为空`)
+	return redis.NewClient(s.option.Redis)
+}
+
+// 定时检查并执行任务，执行中的任务使用开始调度时的任务配置o
+func (s *Scheduler) runTask(o *TaskOption, task Task, state *taskState) {
+	ctx, loopCtx := s.option.RootContext(), state.loop.Context()
+	logger := slog.NewLogger(`task`, o.name)
+	taskCtx := &BaseContext{
+		option: o, ctx: ctx,
+		client: s.client, logger: logger,
+	}
+
+	var locker *rdb.Locker
+	if !o.DisableTaskLock {
+		locker = rdb.NewLocker(s.client)
 	}
 
 	obtainLock := func() (*rdb.SessionLock, error) {
@@ -164,22 +364,83 @@ func (s *Scheduler) runTask(o *TaskOption, task Task) {
 
 	releaseLock := func(lock *rdb.SessionLock) {
 		if lock != nil {
-			if err := lock.Release(ctx); err != nil {
+			if err := lock.ReleaseWithTimeout(lockReleaseTimeout); err != nil {
 				logger.Error(`会话锁释放失败`, zap.String(`key`, o.TaskLockKey()), zap.Error(err))
 			}
 		}
 	}
 
-	async.RunCancelableInterval(ctx, o.InvokeInternal, func(c async.Context) {
-		if c.Done() || s.isRunning.False() {
+	//任务已手动触发则删除触发标记，返回true表示由当前调度器执行
+	takeTrigger := func() (bool, error) {
+		n, err := s.client.Del(ctx, o.TaskTriggerKey()).Result()
+		return n > 0, err
+	}
+
+	getControlState := func() (triggered, paused bool, err error) {
+		var triggerCmd, pauseCmd *redis.IntCmd
+		_, err = s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+			triggerCmd = p.Exists(ctx, o.TaskTriggerKey())
+			pauseCmd = p.Exists(ctx, o.TaskPauseKey())
+			return nil
+		})
+
+		return triggerCmd.Val() > 0, pauseCmd.Val() > 0, err
+	}
+
+	check := func() {
+		state.mu.Lock()
+		defer state.mu.Unlock()
+
+		//任务删除或配置变更后，等待中的检查不再执行
+		if ctx.Err() != nil || loopCtx.Err() != nil || s.isRunning.False() {
+			return
+		}
+
+		state.running.Set(true)
+		defer state.running.Set(false)
+
+		triggered, paused, err := getControlState()
+		if err != nil {
+			logger.Error(`任务控制状态查询出错，等待下次重试`, zap.Error(err))
+			return
+		}
+
+		if o.IsSharded() {
+			s.checkShardedTask(ctx, taskCtx, task, locker, triggered, paused)
+			return
+		}
+
+		if triggered {
+			lock, err := obtainLock()
+			if err != nil {
+				logger.Error(`会话锁获取失败，等待下次重试`, zap.String(`key`, o.TaskLockKey()), zap.Error(err))
+				return
+			}
+			defer releaseLock(lock)
+
+			if ok, err := takeTrigger(); err != nil || !ok {
+				return
+			}
+
+			if err = s.invokeTask(taskCtx, task, 1); err != nil {
+				logger.Error(`手动触发任务执行出错`, zap.Error(err))
+				return
+			}
+
+			logger.Info(`手动触发任务执行完成`)
+			return
+		}
+
+		if paused {
 			return
 		}
 
-		if !isInInvokeTimeRange() {
+		now := o.Now()
+		if !o.IsInInvokeTime(now) {
 			return
 		}
 
-		if ok, err := isTaskNextInvokeTimeUp(); err != nil || !ok {
+		if ok, err := taskCtx.isTaskNextInvokeTimeUp(now); err != nil || !ok {
 			if err != nil {
 				logger.Error(`任务下次执行时间查询出错，等待下次重试`, zap.String(`key`, o.TaskInvokeTimeKey()), zap.Error(err))
 			}
@@ -193,12 +454,119 @@ func (s *Scheduler) runTask(o *TaskOption, task Task) {
 		}
 		defer releaseLock(lock)
 
-		if err = task.Run(taskCtx); err != nil {
+		if o.IsCron() {
+			s.runCronTask(ctx, taskCtx, task, now)
+			return
+		}
+
+		if err = s.invokeTask(taskCtx, task, 1); err != nil {
 			logger.Error(`任务执行出错`, zap.Error(err))
 			return
 		}
 
 		next, _ := taskCtx.GetTaskNextInvokeTime()
 		logger.Info(`任务执行完成`, zap.Time(`下次执行时间`, next))
+	}
+
+	async.RunCancelableInterval(loopCtx, o.InvokeInternal, func(c async.Context) {
+		if !c.Done() {
+			check()
+		}
 	})
+
+	go func() {
+		for {
+			select {
+			case <-loopCtx.Done():
+				return
+			case <-state.wake:
+				check()
+			}
+		}
+	}()
+}
+
+// 执行Cron任务，先将下次执行时间设置为now之后的cron执行时间，再根据错过执行策略执行任务
+// 使用CAS设置下次执行时间，未启用任务锁时也只有1个调度器执行
+func (s *Scheduler) runCronTask(ctx context.Context, taskCtx *BaseContext, task Task, now time.Time) {
+	logger := taskCtx.logger
+
+	times, newNext := s.claimCronTask(taskCtx, now)
+	if len(times) == 0 {
+		return
+	}
+
+	var err error
+	for i, t := range times {
+		if ctx.Err() != nil || s.isRunning.False() {
+			return
+		}
+
+		if err = s.invokeTask(taskCtx.withScheduledTime(t), task, i+1); err != nil {
+			logger.Error(`任务执行出错`, zap.Time(`执行时间`, t), zap.Error(err))
+		}
+	}
+
+	logger.Info(`任务执行完成`, zap.Time(`下次执行时间`, newNext))
+}
+
+// 使用CAS将下次执行时间设置为now之后的cron执行时间，成功则返回根据错过执行策略需执行的cron执行时间
+func (s *Scheduler) claimCronTask(taskCtx *BaseContext, now time.Time) (times []time.Time, newNext time.Time) {
+	o, logger := taskCtx.option, taskCtx.logger
+
+	next, err := taskCtx.GetTaskNextInvokeTime()
+	if err != nil || next.IsZero() || now.Before(next) {
+		return nil, newNext
+	}
+
+	newNext = o.NextInvokeTime(now)
+	if ok, err := taskCtx.casTaskNextInvokeTime(next, newNext); err != nil || !ok {
+		if err != nil {
+			logger.Error(`任务下次执行时间设置出错，等待下次重试`, zap.String(`key`, o.TaskInvokeTimeKey()), zap.Error(err))
+		}
+		return nil, newNext
+	}
+
+	if times = o.dueInvokeTimes(next, now); len(times) == 0 {
+		logger.Info(`跳过错过的执行`, zap.Time(`错过执行时间`, next), zap.Time(`下次执行时间`, newNext))
+	}
+
+	return times, newNext
+}
+
+// 执行任务并保存执行记录，设置TaskOption.Timeout则任务Context.Context()在超时后结束
+func (s *Scheduler) invokeTask(taskCtx *BaseContext, task Task, catchUpIndex int) error {
+	if timeout := taskCtx.option.Timeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(taskCtx.ctx, timeout)
+		defer cancel()
+
+		taskCtx = taskCtx.withContext(ctx)
+	}
+
+	run := &TaskRun{
+		Task:          taskCtx.option.name,
+		Node:          s.option.Node,
+		ScheduledTime: taskCtx.scheduledTime,
+		ShardIndex:    taskCtx.shardIndex,
+		ShardCount:    taskCtx.shardCount,
+		StartTime:     time.Now(),
+		CatchUpIndex:  catchUpIndex,
+	}
+
+	err := task.Run(taskCtx)
+	run.EndTime = time.Now()
+	run.Duration = run.EndTime.Sub(run.StartTime)
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	//调度器停止时root ctx已取消，不使用root ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if e := s.saveTaskRun(ctx, taskCtx.option, run); e != nil {
+		taskCtx.logger.Error(`任务执行记录保存出错`, zap.String(`key`, taskCtx.option.TaskHistoryKey()), zap.Error(e))
+	}
+
+	return err
 }
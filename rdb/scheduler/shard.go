@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// 开始分片执行，KEYS：run,done，ARGV：id,scheduled。已有执行中的分片执行返回0
+var shardRunStartScript = redis.NewScript(`
+	if redis.call('EXISTS', KEYS[1]) == 1 then
+		return 0
+	end
+	redis.call('DEL', KEYS[2])
+	redis.call('HSET', KEYS[1], 'id', ARGV[1], 'scheduled', ARGV[2])
+	return 1
+    `)
+
+// 标记分片已完成，KEYS：run,done，ARGV：id,shard,shards
+// 返回-1表示分片执行已变更，1表示全部分片已完成并删除分片执行状态，0表示仍有分片未完成
+var shardDoneScript = redis.NewScript(`
+	if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+		return -1
+	end
+	redis.call('SADD', KEYS[2], ARGV[2])
+	if redis.call('SCARD', KEYS[2]) >= tonumber(ARGV[3]) then
+		redis.call('DEL', KEYS[1], KEYS[2])
+		return 1
+	end
+	return 0
+    `)
+
+// IsSharded 是否为分片任务
+func (o *TaskOption) IsSharded() bool {
+	return o.Shards > 1
+}
+
+// 分片执行
+type shardRun struct {
+	id        string
+	scheduled time.Time
+}
+
+// 开始分片执行，返回false表示已有执行中的分片执行
+func (s *Scheduler) startShardRun(ctx context.Context, o *TaskOption, id string, scheduled time.Time) (bool, error) {
+	var ts int64
+	if !scheduled.IsZero() {
+		ts = scheduled.Unix()
+	}
+
+	return shardRunStartScript.Run(ctx, s.client, []string{o.TaskShardRunKey(), o.TaskShardDoneKey()}, id, ts).Bool()
+}
+
+// 查询执行中的分片执行及已完成的分片，无执行中的分片执行返回nil
+func (s *Scheduler) getShardRun(ctx context.Context, o *TaskOption) (*shardRun, map[int]bool, error) {
+	var runCmd *redis.StringStringMapCmd
+	var doneCmd *redis.StringSliceCmd
+	_, err := s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		runCmd = p.HGetAll(ctx, o.TaskShardRunKey())
+		doneCmd = p.SMembers(ctx, o.TaskShardDoneKey())
+		return nil
+	})
+
+	if err != nil || len(runCmd.Val()) == 0 {
+		return nil, nil, err
+	}
+
+	m := runCmd.Val()
+	run := &shardRun{id: m[`id`]}
+	if ts, _ := strconv.ParseInt(m[`scheduled`], 10, 64); ts > 0 {
+		run.scheduled = time.Unix(ts, 0).In(o.location)
+	}
+
+	done := make(map[int]bool)
+	for _, v := range doneCmd.Val() {
+		if i, err := strconv.Atoi(v); err == nil {
+			done[i] = true
+		}
+	}
+
+	return run, done, nil
+}
+
+// 检查分片任务，满足执行条件则开始1次分片执行，然后执行未完成的分片
+// 上次分片执行未完成时不会开始新的分片执行，Cron任务错过多次执行时仅执行最近1次
+func (s *Scheduler) checkShardedTask(ctx context.Context, taskCtx *BaseContext, task Task, locker *rdb.Locker, triggered, paused bool) {
+	o, logger := taskCtx.option, taskCtx.logger
+
+	var id string
+	var scheduled time.Time
+	now := o.Now()
+	switch {
+	case triggered:
+		//仅删除触发标记成功的调度器开始分片执行
+		if n, err := s.client.Del(ctx, o.TaskTriggerKey()).Result(); err != nil || n == 0 {
+			break
+		}
+		id = fmt.Sprintf(`trigger:%v`, now.UnixNano())
+	case paused || !o.IsInInvokeTime(now):
+	default:
+		if ok, err := taskCtx.isTaskNextInvokeTimeUp(now); err != nil || !ok {
+			if err != nil {
+				logger.Error(`任务下次执行时间查询出错，等待下次重试`, zap.String(`key`, o.TaskInvokeTimeKey()), zap.Error(err))
+			}
+			break
+		}
+
+		if !o.IsCron() {
+			id = fmt.Sprintf(`%v`, now.UnixNano())
+			break
+		}
+
+		if times, _ := s.claimCronTask(taskCtx, now); len(times) > 0 {
+			scheduled = times[len(times)-1]
+			id = fmt.Sprintf(`%v`, scheduled.Unix())
+		}
+	}
+
+	if id != `` {
+		ok, err := s.startShardRun(ctx, o, id, scheduled)
+		if err != nil {
+			logger.Error(`分片执行开始出错`, zap.String(`key`, o.TaskShardRunKey()), zap.Error(err))
+		} else if !ok && (triggered || o.IsCron()) {
+			logger.Warn(`上次分片执行未完成，忽略本次执行`, zap.String(`run`, id))
+		}
+	}
+
+	s.runShards(ctx, taskCtx, task, locker)
+}
+
+// 执行未完成的分片，每个分片需获取分片锁。从随机分片开始，减少多个调度器节点竞争同1个分片锁
+// 持有分片锁的节点崩溃后，分片锁过期，其他节点将重新执行此分片。分片执行失败将在下次检查时重试
+func (s *Scheduler) runShards(ctx context.Context, taskCtx *BaseContext, task Task, locker *rdb.Locker) {
+	o, logger := taskCtx.option, taskCtx.logger
+
+	run, done, err := s.getShardRun(ctx, o)
+	if err != nil || run == nil {
+		if err != nil {
+			logger.Error(`分片执行状态查询出错，等待下次重试`, zap.String(`key`, o.TaskShardRunKey()), zap.Error(err))
+		}
+		return
+	}
+
+	start := rand.Intn(o.Shards)
+	for n := 0; n < o.Shards; n++ {
+		shard := (start + n) % o.Shards
+		if done[shard] {
+			continue
+		}
+
+		if ctx.Err() != nil || s.isRunning.False() {
+			return
+		}
+
+		if completed := s.runShard(ctx, taskCtx, task, locker, run, shard); completed {
+			return
+		}
+	}
+}
+
+// 执行1个分片，返回true表示分片执行已结束
+func (s *Scheduler) runShard(ctx context.Context, taskCtx *BaseContext, task Task, locker *rdb.Locker, run *shardRun, shard int) bool {
+	o, logger := taskCtx.option, taskCtx.logger
+
+	lock, err := locker.ObtainSessionLock(ctx, o.TaskShardLockKey(shard), o.TaskLockTTL)
+	if err != nil {
+		return false
+	}
+
+	defer func() {
+		if err := lock.ReleaseWithTimeout(lockReleaseTimeout); err != nil {
+			logger.Error(`分片锁释放失败`, zap.String(`key`, o.TaskShardLockKey(shard)), zap.Error(err))
+		}
+	}()
+
+	//获取分片锁后重新检查，分片可能已被其他节点完成
+	cur, done, err := s.getShardRun(ctx, o)
+	if err != nil || cur == nil || cur.id != run.id {
+		return cur == nil
+	}
+
+	if done[shard] {
+		return false
+	}
+
+	if err = s.invokeTask(taskCtx.withScheduledTime(run.scheduled).withShard(shard, o.Shards), task, 1); err != nil {
+		logger.Error(`分片任务执行出错，等待下次重试`, zap.Int(`shard`, shard), zap.Error(err))
+		return false
+	}
+
+	rs, err := shardDoneScript.Run(ctx, s.client, []string{o.TaskShardRunKey(), o.TaskShardDoneKey()}, run.id, shard, o.Shards).Int64()
+	if err != nil {
+		logger.Error(`分片完成状态保存出错`, zap.Int(`shard`, shard), zap.Error(err))
+		return false
+	}
+
+	if rs == 1 {
+		logger.Info(`分片任务执行完成`, zap.String(`run`, run.id), zap.Int(`shards`, o.Shards))
+		s.delayNextShardRun(taskCtx)
+	}
+
+	return rs != 0
+}
+
+// 非Cron分片任务全部分片完成后，下次执行时间至少推迟InvokeInternal(按秒向上取整)，避免下次检查时立即开始新的分片执行
+// 任务已设置更晚的下次执行时间则不变
+func (s *Scheduler) delayNextShardRun(taskCtx *BaseContext) {
+	o := taskCtx.option
+	if o.IsCron() {
+		return
+	}
+
+	next := o.Now().Add(o.InvokeInternal)
+	if t := next.Truncate(time.Second); t.Before(next) {
+		next = t.Add(time.Second)
+	}
+
+	cur, err := taskCtx.GetTaskNextInvokeTime()
+	if err == nil && cur.Before(next) {
+		err = taskCtx.SetTaskNextInvokeTime(next)
+	}
+
+	if err != nil {
+		taskCtx.logger.Error(`分片任务下次执行时间保存出错`, zap.String(`key`, o.TaskInvokeTimeKey()), zap.Error(err))
+	}
+}
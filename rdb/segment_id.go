@@ -0,0 +1,200 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// 申请号段，ARGV：step,floor。如果当前值小于floor(如redis数据丢失)，则从floor开始分配。返回号段最大值
+var segmentIDIncrScript = redis.NewScript(`
+	local v = tonumber(redis.call('GET', KEYS[1]) or '0')
+	local floor = tonumber(ARGV[2])
+	if v < floor then
+		redis.call('SET', KEYS[1], floor)
+	end
+	return redis.call('INCRBY', KEYS[1], ARGV[1])
+    `)
+
+// SegmentStore 号段最大值持久化存储，用于redis数据丢失后恢复，见orm.NewSegmentStore()
+type SegmentStore interface {
+	// Load 查询已分配的号段最大值，不存在返回0
+	Load(ctx context.Context, name string) (int64, error)
+
+	// Save 保存已分配的号段最大值，不应减小已保存的值
+	Save(ctx context.Context, name string, maxID int64) error
+}
+
+type SegmentIDAllocatorOption struct {
+	Name          string  //号段名称
+	KeyPrefix     string  //redis key前缀，默认seg:
+	Step          int64   //号段长度，默认1000
+	PrefetchRatio float64 //当前号段剩余比例低于此值时后台预取下1号段，默认0.2
+	RetryCount    int     //申请号段失败重试次数，默认3
+}
+
+func (o *SegmentIDAllocatorOption) MustNormalize() *SegmentIDAllocatorOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertOk(!_string.Empty(o.Name), `Name为空`)
+
+	if _string.Empty(o.KeyPrefix) {
+		o.KeyPrefix = `seg:`
+	}
+
+	if o.Step <= 0 {
+		o.Step = 1000
+	}
+
+	if o.PrefetchRatio <= 0 || o.PrefetchRatio >= 1 {
+		o.PrefetchRatio = 0.2
+	}
+
+	if o.RetryCount <= 0 {
+		o.RetryCount = 3
+	}
+
+	return o
+}
+
+// 号段，可分配的ID范围为(max-step,max]
+type idSegment struct {
+	next, max int64
+}
+
+func (s *idSegment) remaining() int64 {
+	if s == nil {
+		return 0
+	}
+
+	return s.max - s.next + 1
+}
+
+// SegmentIDAllocator 号段ID分配器，从redis批量申请ID号段并在内存里分配，生成全局唯一且趋势递增的ID
+// 当前号段剩余不足时后台预取下1号段，redis短暂不可用时可继续使用已申请的号段
+type SegmentIDAllocator struct {
+	option *SegmentIDAllocatorOption
+	client redis.UniversalClient
+	store  SegmentStore
+	logger *zap.Logger
+
+	mu          sync.Mutex
+	cur         *idSegment
+	prefetched  *idSegment
+	prefetching bool
+}
+
+func MustNewSegmentIDAllocator(option *SegmentIDAllocatorOption, client redis.UniversalClient) *SegmentIDAllocator {
+	util.AssertOk(client != nil, `client为空`)
+
+	return &SegmentIDAllocator{
+		option: option.MustNormalize(),
+		client: client,
+		logger: newLogger(`segment_id`),
+	}
+}
+
+// WithStore 设置号段持久化存储，每次申请号段后保存号段最大值，redis数据丢失后将从保存的值继续分配
+func (a *SegmentIDAllocator) WithStore(store SegmentStore) *SegmentIDAllocator {
+	a.store = store
+	return a
+}
+
+func (a *SegmentIDAllocator) Option() *SegmentIDAllocatorOption {
+	return a.option
+}
+
+func (a *SegmentIDAllocator) Key() string {
+	return a.option.KeyPrefix + a.option.Name
+}
+
+// Next 分配1个ID
+func (a *SegmentIDAllocator) Next(ctx context.Context) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cur.remaining() <= 0 {
+		if a.prefetched != nil {
+			a.cur, a.prefetched = a.prefetched, nil
+		} else {
+			seg, err := a.fetch(ctx)
+			if err != nil {
+				return 0, err
+			}
+
+			a.cur = seg
+		}
+	}
+
+	id := a.cur.next
+	a.cur.next++
+
+	if !a.prefetching && a.prefetched == nil &&
+		float64(a.cur.remaining()) < float64(a.option.Step)*a.option.PrefetchRatio {
+		a.prefetching = true
+		go a.prefetch()
+	}
+
+	return id, nil
+}
+
+func (a *SegmentIDAllocator) prefetch() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	seg, err := a.fetch(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.prefetching = false
+	if err != nil {
+		a.logger.Warn(`预取号段出错`, zap.String(`name`, a.option.Name), zap.Error(err))
+		return
+	}
+
+	a.prefetched = seg
+}
+
+// 申请号段，失败将重试
+func (a *SegmentIDAllocator) fetch(ctx context.Context) (seg *idSegment, err error) {
+	counter := util.NewStepRetryCounter(a.option.RetryCount, 50*time.Millisecond, 50*time.Millisecond, 500*time.Millisecond)
+	err = util.DoRetry(counter, func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		seg, err = a.doFetch(ctx)
+		return err
+	})
+
+	return
+}
+
+func (a *SegmentIDAllocator) doFetch(ctx context.Context) (*idSegment, error) {
+	var floor int64
+	if a.store != nil {
+		v, err := a.store.Load(ctx, a.option.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		floor = v
+	}
+
+	max, err := segmentIDIncrScript.Run(ctx, a.client, []string{a.Key()}, a.option.Step, floor).Int64()
+	if err != nil {
+		return nil, err
+	}
+
+	if a.store != nil {
+		if err = a.store.Save(ctx, a.option.Name, max); err != nil {
+			a.logger.Warn(`保存号段出错`, zap.String(`name`, a.option.Name), zap.Int64(`max`, max), zap.Error(err))
+		}
+	}
+
+	return &idSegment{next: max - a.option.Step + 1, max: max}, nil
+}
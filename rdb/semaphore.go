@@ -0,0 +1,125 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/bsm/redislock"
+	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"strconv"
+	"time"
+)
+
+// 信号量使用zset保存许可持有者，member为持有者，score为租约到期时间戳(ms)
+// 租约过期的持有者(如进程崩溃)将在下次获取许可时被清理
+var (
+	//获取许可，ARGV：owner,ttl,now,permits。成功返回1
+	semaphoreAcquireScript = redis.NewScript(`
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+	if redis.call('ZSCORE', KEYS[1], ARGV[1]) or redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[4]) then
+		local t = tonumber(ARGV[3]) + tonumber(ARGV[2])
+		redis.call('ZADD', KEYS[1], t, ARGV[1])
+		if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+			redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		end
+		return 1
+	end
+	return 0
+    `)
+
+	//续约许可，ARGV：owner,ttl,now。成功返回1
+	semaphoreRefreshScript = redis.NewScript(`
+	local s = redis.call('ZSCORE', KEYS[1], ARGV[1])
+	if not s or tonumber(s) < tonumber(ARGV[3]) then
+		return 0
+	end
+	local t = tonumber(ARGV[3]) + tonumber(ARGV[2])
+	redis.call('ZADD', KEYS[1], t, ARGV[1])
+	if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	end
+	return 1
+    `)
+
+	//释放许可，ARGV：owner。成功返回1，未持有许可返回-1
+	semaphoreReleaseScript = redis.NewScript(`
+	if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+		return 1
+	end
+	return -1
+    `)
+)
+
+// Semaphore 分布式信号量，最多permits个持有者同时持有许可
+type Semaphore struct {
+	locker  *Locker
+	name    string
+	permits int64
+}
+
+// Semaphore 创建分布式信号量，获取许可的重试策略与锁续约失败回调函数使用Locker的设置
+func (l *Locker) Semaphore(name string, permits int64) *Semaphore {
+	util.AssertNotEmpty(name, `name为空`)
+	util.AssertOk(permits > 0, `permits<=0`)
+
+	return &Semaphore{
+		locker:  l,
+		name:    name,
+		permits: permits,
+	}
+}
+
+func (s *Semaphore) Name() string {
+	return s.name
+}
+
+func (s *Semaphore) Permits() int64 {
+	return s.permits
+}
+
+// Acquire 获取1个许可，返回的会话锁将自动续约租约，使用完成后应调用Release()释放许可
+// 获取失败返回redislock.ErrNotObtained
+func (s *Semaphore) Acquire(ctx context.Context, leaseTTL time.Duration) (*SessionLock, error) {
+	h := &scriptLockHandle{
+		client:        s.locker.client,
+		keys:          []string{s.name},
+		owner:         shortuuid.New(),
+		refreshScript: semaphoreRefreshScript,
+		releaseScript: semaphoreReleaseScript,
+	}
+
+	lock, err := s.locker.obtainLock(ctx, leaseTTL, func() (lockHandle, error) {
+		ok, err := semaphoreAcquireScript.Run(ctx, h.client, h.keys,
+			h.owner, leaseTTL.Milliseconds(), time.Now().UnixMilli(), s.permits).Bool()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return nil, redislock.ErrNotObtained
+		}
+
+		return h, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSessionLock(lock, leaseTTL, s.locker.onLockRefreshFailedFn), nil
+}
+
+// Available 可用许可数量
+func (s *Semaphore) Available(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	n, err := s.locker.client.ZCount(ctx, s.name, `(`+now, `+inf`).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if n >= s.permits {
+		return 0, nil
+	}
+
+	return s.permits - n, nil
+}
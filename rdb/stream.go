@@ -0,0 +1,341 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"github.com/lithammer/shortuuid/v4"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"time"
+)
+
+type StreamConsumerOption struct {
+	Stream           string        //消息流名称
+	Group            string        //消费组名称
+	Consumer         string        //消费者名称，默认hostname-随机字符串
+	StartID          string        //消费组不存在时创建消费组的起始消息ID，默认$，即仅消费新消息
+	Concurrency      int           //消息处理协程数量，默认1
+	BatchSize        int64         //每次读取消息数量，默认10
+	Block            time.Duration //读取消息阻塞时长，默认2s
+	ClaimInterval    time.Duration //认领超时未确认消息的时间间隔，默认30s
+	ClaimMinIdle     time.Duration //消息超过此时长未确认将被认领并重新处理，默认1m
+	MaxDeliveries    int64         //消息最大投递次数，超过则转移到死信消息流，默认5
+	DeadLetterStream string        //死信消息流名称，默认Stream:dead
+	MaxLen           int64         //消息流最大长度(近似)，定时裁剪，默认0不裁剪
+}
+
+func (o *StreamConsumerOption) MustNormalize() *StreamConsumerOption {
+	util.AssertOk(o != nil, `option为空`)
+	util.AssertOk(!_string.Empty(o.Stream), `Stream为空`)
+	util.AssertOk(!_string.Empty(o.Group), `Group为空`)
+
+	if _string.Empty(o.Consumer) {
+		host, _ := os.Hostname()
+		o.Consumer = host + `-` + shortuuid.New()[:8]
+	}
+
+	if _string.Empty(o.StartID) {
+		o.StartID = `$`
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = 1
+	}
+
+	if o.BatchSize <= 0 {
+		o.BatchSize = 10
+	}
+
+	if o.Block <= 0 {
+		o.Block = 2 * time.Second
+	}
+
+	if o.ClaimInterval <= 0 {
+		o.ClaimInterval = 30 * time.Second
+	}
+
+	if o.ClaimMinIdle <= 0 {
+		o.ClaimMinIdle = 1 * time.Minute
+	}
+
+	if o.MaxDeliveries <= 0 {
+		o.MaxDeliveries = 5
+	}
+
+	if _string.Empty(o.DeadLetterStream) {
+		o.DeadLetterStream = o.Stream + `:dead`
+	}
+
+	return o
+}
+
+// StreamHandler 消息处理函数，返回nil则自动确认消息，否则消息将在ClaimMinIdle后被重新认领处理
+type StreamHandler func(ctx context.Context, msg redis.XMessage) error
+
+// StreamConsumer 消息流消费者，基于消费组实现，消息至少处理1次，处理函数应保证幂等
+// 实现接口async.Runner，调用Stop()将停止读取消息并等待已读取的消息处理完成
+type StreamConsumer struct {
+	option  *StreamConsumerOption
+	client  redis.UniversalClient
+	handler StreamHandler
+	logger  *zap.Logger
+	runner  *async.TaskRunner
+}
+
+func MustNewStreamConsumer(option *StreamConsumerOption, client redis.UniversalClient, handler StreamHandler) *StreamConsumer {
+	util.AssertOk(client != nil, `client为空`)
+	util.AssertOk(handler != nil, `handler为空`)
+
+	c := &StreamConsumer{
+		option:  option.MustNormalize(),
+		client:  client,
+		handler: handler,
+		logger:  newLogger(`stream`),
+	}
+
+	c.runner = async.NewTaskRunner(async.BgTaskFn(c.run))
+	return c
+}
+
+func (c *StreamConsumer) Option() *StreamConsumerOption {
+	return c.option
+}
+
+func (c *StreamConsumer) Start() {
+	c.runner.Start()
+}
+
+func (c *StreamConsumer) Stop() {
+	c.runner.Stop()
+}
+
+func (c *StreamConsumer) IsRunning() bool {
+	return c.runner.IsRunning()
+}
+
+// EnsureGroup 创建消费组，消费组已存在则忽略
+func (c *StreamConsumer) EnsureGroup(ctx context.Context) error {
+	err := c.client.XGroupCreateMkStream(ctx, c.option.Stream, c.option.Group, c.option.StartID).Err()
+	if err != nil && strings.HasPrefix(err.Error(), `BUSYGROUP`) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *StreamConsumer) run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	msgs := make(chan redis.XMessage, c.option.BatchSize)
+
+	workers := async.NewRoutineGroup(c.option.Concurrency, func() {
+		for msg := range msgs {
+			c.handle(msg)
+		}
+	})
+	workers.Start()
+
+	claimDone := async.RunCancelableInterval(ctx, c.option.ClaimInterval, func(rc async.Context) {
+		if rc.Done() {
+			return
+		}
+
+		c.claim(ctx, msgs)
+		c.trim(ctx)
+	})
+
+	go func() {
+		defer close(done)
+
+		c.read(ctx, msgs)
+		<-claimDone
+		close(msgs)
+		workers.Wait()
+	}()
+
+	return done
+}
+
+// 读取新消息，直到ctx取消
+func (c *StreamConsumer) read(ctx context.Context, msgs chan<- redis.XMessage) {
+	o := c.option
+	for ctx.Err() == nil {
+		if err := c.EnsureGroup(ctx); err != nil {
+			c.logger.Error(`创建消费组出错`, zap.String(`stream`, o.Stream), zap.Error(err))
+			c.sleep(ctx, o.Block)
+			continue
+		}
+
+		break
+	}
+
+	for ctx.Err() == nil {
+		rs, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    o.Group,
+			Consumer: o.Consumer,
+			Streams:  []string{o.Stream, `>`},
+			Count:    o.BatchSize,
+			Block:    o.Block,
+		}).Result()
+
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error(`读取消息出错`, zap.String(`stream`, o.Stream), zap.Error(err))
+				c.sleep(ctx, o.Block)
+			}
+			continue
+		}
+
+		for _, stream := range rs {
+			for _, msg := range stream.Messages {
+				msgs <- msg
+			}
+		}
+	}
+}
+
+func (c *StreamConsumer) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (c *StreamConsumer) handle(msg redis.XMessage) {
+	//已读取的消息在停止时仍处理完成，所以不使用runner的ctx
+	ctx := context.Background()
+	if err := c.doHandle(ctx, msg); err != nil {
+		c.logger.Warn(`消息处理失败`, zap.String(`stream`, c.option.Stream), zap.String(`id`, msg.ID), zap.Error(err))
+		return
+	}
+
+	if err := c.client.XAck(ctx, c.option.Stream, c.option.Group, msg.ID).Err(); err != nil {
+		c.logger.Error(`确认消息出错`, zap.String(`stream`, c.option.Stream), zap.String(`id`, msg.ID), zap.Error(err))
+	}
+}
+
+func (c *StreamConsumer) doHandle(ctx context.Context, msg redis.XMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(`消息处理崩溃->%v`, r)
+		}
+	}()
+
+	return c.handler(ctx, msg)
+}
+
+// 认领超时未确认的消息，投递次数超过上限的消息转移到死信消息流
+func (c *StreamConsumer) claim(ctx context.Context, msgs chan<- redis.XMessage) {
+	o := c.option
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: o.Stream,
+		Group:  o.Group,
+		Idle:   o.ClaimMinIdle,
+		Start:  `-`,
+		End:    `+`,
+		Count:  o.BatchSize * int64(o.Concurrency),
+	}).Result()
+
+	if err != nil {
+		if ctx.Err() == nil && err != redis.Nil {
+			c.logger.Error(`查询待确认消息出错`, zap.String(`stream`, o.Stream), zap.Error(err))
+		}
+		return
+	}
+
+	var ids []string
+	for _, p := range pending {
+		if p.RetryCount < o.MaxDeliveries {
+			ids = append(ids, p.ID)
+			continue
+		}
+
+		if err := c.moveToDeadLetter(ctx, p.ID, p.RetryCount); err != nil {
+			c.logger.Error(`转移死信消息出错`, zap.String(`stream`, o.Stream), zap.String(`id`, p.ID), zap.Error(err))
+		}
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	claimed, err := c.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   o.Stream,
+		Group:    o.Group,
+		Consumer: o.Consumer,
+		MinIdle:  o.ClaimMinIdle,
+		Messages: ids,
+	}).Result()
+
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Error(`认领消息出错`, zap.String(`stream`, o.Stream), zap.Error(err))
+		}
+		return
+	}
+
+	for _, msg := range claimed {
+		msgs <- msg
+	}
+}
+
+// 转移消息到死信消息流并确认消息，死信消息增加字段：_stream,_id,_deliveries
+func (c *StreamConsumer) moveToDeadLetter(ctx context.Context, id string, deliveries int64) error {
+	o := c.option
+	rs, err := c.client.XRangeN(ctx, o.Stream, id, id, 1).Result()
+	if err != nil {
+		return err
+	}
+
+	//消息可能已被裁剪
+	values := map[string]interface{}{}
+	if len(rs) > 0 {
+		for k, v := range rs[0].Values {
+			values[k] = v
+		}
+	}
+	values[`_stream`] = o.Stream
+	values[`_id`] = id
+	values[`_deliveries`] = deliveries
+
+	//死信消息流可能与消息流不在同1个slot，不使用事务
+	_, err = c.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.XAdd(ctx, &redis.XAddArgs{Stream: o.DeadLetterStream, Values: values})
+		p.XAck(ctx, o.Stream, o.Group, id)
+		return nil
+	})
+
+	if err == nil {
+		c.logger.Warn(`消息投递次数达到上限，转移到死信消息流`, zap.String(`stream`, o.Stream), zap.String(`id`, id))
+	}
+
+	return err
+}
+
+func (c *StreamConsumer) trim(ctx context.Context) {
+	if c.option.MaxLen <= 0 {
+		return
+	}
+
+	if err := c.client.XTrimMaxLenApprox(ctx, c.option.Stream, c.option.MaxLen, 0).Err(); err != nil && ctx.Err() == nil {
+		c.logger.Error(`裁剪消息流出错`, zap.String(`stream`, c.option.Stream), zap.Error(err))
+	}
+}
+
+// PublishStream 发布消息，如果maxLen>0则近似裁剪消息流到maxLen，返回消息ID
+func PublishStream(ctx context.Context, client redis.Cmdable, stream string, maxLen int64, values interface{}) (string, error) {
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+		Values: values,
+	}).Result()
+}
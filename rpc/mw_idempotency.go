@@ -0,0 +1,180 @@
+package rpc
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/util"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"strings"
+)
+
+type MWIdempotencyOption struct {
+	MDKey    string   //幂等键metadata名称，默认idempotency-key
+	Methods  []string //需要幂等处理的方法名称或前缀，为空则处理全部提供幂等键的一元调用
+	Required bool     //匹配的方法是否必须提供幂等键，否则返回codes.InvalidArgument
+}
+
+func (o *MWIdempotencyOption) MustNormalize() *MWIdempotencyOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if _string.Empty(o.MDKey) {
+		o.MDKey = `idempotency-key`
+	}
+
+	o.MDKey = strings.ToLower(o.MDKey)
+	return o
+}
+
+// MWIdempotency 幂等中间件，仅服务端一元调用拦截器处理，客户端拦截器将ctx里的幂等键写入metadata
+// 相同幂等键的重复调用将重放首次调用的响应，首次调用处理中则返回codes.Aborted
+// 仅保存调用成功的响应，调用失败则释放幂等键以允许客户端重试
+type MWIdempotency struct {
+	option *MWIdempotencyOption
+	store  *rdb.IdempotencyStore
+	logger *zap.Logger
+}
+
+func NewMWIdempotency(store *rdb.IdempotencyStore, option *MWIdempotencyOption) *MWIdempotency {
+	util.AssertOk(store != nil, `store为空`)
+
+	return &MWIdempotency{
+		option: option.MustNormalize(),
+		store:  store,
+		logger: newLogger(`MWIdempotency`),
+	}
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// IdempotencyKeyIntoContext 设置幂等键，客户端拦截器将写入metadata
+func IdempotencyKeyIntoContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+func (m *MWIdempotency) match(method string) bool {
+	if len(m.option.Methods) == 0 {
+		return true
+	}
+
+	for _, prefix := range m.option.Methods {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *MWIdempotency) NewUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if key, ok := ctx.Value(idempotencyKeyCtxKey{}).(string); ok && key != `` {
+			ctx = metadata.AppendToOutgoingContext(ctx, m.option.MDKey, key)
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (m *MWIdempotency) NewStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (m *MWIdempotency) NewUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !m.match(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		idemKey, _ := GetMDVal(md, m.option.MDKey, 0)
+		if idemKey == `` {
+			if m.option.Required {
+				return nil, status.Errorf(codes.InvalidArgument, `缺少metadata[%v]`, m.option.MDKey)
+			}
+
+			return handler(ctx, req)
+		}
+
+		entry, err := m.store.Begin(ctx, info.FullMethod+`:`+idemKey)
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, `idempotency check err->%v`, err)
+		}
+
+		switch entry.State {
+		case rdb.IdempotencyStateInProgress:
+			return nil, ToRpcErr(util.NewBizError(util.ErrCodeAborted, `请求处理中`))
+		case rdb.IdempotencyStateCompleted:
+			_ = grpc.SetHeader(ctx, metadata.Pairs(`idempotent-replayed`, `true`))
+			return m.replay(entry.Record)
+		}
+
+		//调用方可能已取消，不使用请求ctx
+		bgCtx := context.Background()
+		abort := func() {
+			if _, e := m.store.Abort(bgCtx, entry); e != nil {
+				m.logger.Error(`释放幂等键出错`, zap.String(`key`, idemKey), zap.Error(e))
+			}
+		}
+
+		//处理调用崩溃时释放幂等键后继续抛出，否则重试调用将一直返回codes.Aborted直到幂等键过期
+		defer func() {
+			if r := recover(); r != nil {
+				abort()
+				panic(r)
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+		msg, ok := resp.(proto.Message)
+		if err != nil || !ok {
+			abort()
+			return resp, err
+		}
+
+		body, e := proto.Marshal(msg)
+		if e == nil {
+			_, e = m.store.Complete(bgCtx, entry, &rdb.IdempotencyRecord{
+				Status:      int(codes.OK),
+				ContentType: GetMsgFullName(msg),
+				Body:        body,
+			})
+		}
+
+		if e != nil {
+			m.logger.Error(`保存幂等响应出错`, zap.String(`key`, idemKey), zap.Error(e))
+		}
+
+		return resp, nil
+	}
+}
+
+// 根据保存的响应消息名称创建响应消息
+func (m *MWIdempotency) replay(record *rdb.IdempotencyRecord) (interface{}, error) {
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(record.ContentType))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, `idempotency replay err->%v`, err)
+	}
+
+	msg := mt.New().Interface()
+	if err = proto.Unmarshal(record.Body, msg); err != nil {
+		return nil, status.Errorf(codes.Internal, `idempotency replay err->%v`, err)
+	}
+
+	return msg, nil
+}
+
+func (m *MWIdempotency) NewStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		return handler(srv, ss)
+	}
+}
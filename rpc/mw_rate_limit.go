@@ -0,0 +1,256 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/_string"
+	"github.com/bingooh/b-go-util/conf"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 限流key来源
+const (
+	RateLimitKeyByIP     = `ip`     //客户端IP
+	RateLimitKeyByAuth   = `auth`   //auth value，如不存在则使用客户端IP。依赖中间件MWAuthenticator
+	RateLimitKeyByMethod = `method` //方法，即全部客户端共享限流配额
+)
+
+type MWRateLimitRule struct {
+	Method            string //方法名称或前缀，如：/pkg.Service/Method，/pkg.Service/
+	KeyBy             string //限流key来源，为空则使用MWRateLimitOption.KeyBy
+	rdb.LimiterOption `mapstructure:",squash"`
+}
+
+type MWRateLimitOption struct {
+	Disabled  bool               //是否禁用
+	KeyPrefix string             //限流redis key前缀，默认rl:grpc:
+	KeyBy     string             //限流key来源：ip/auth/method，默认ip
+	Default   *rdb.LimiterOption //未匹配方法的限流配置，为空则不限流
+	Rules     []*MWRateLimitRule //方法限流配置，优先匹配最长的方法名称前缀
+}
+
+func (o *MWRateLimitOption) MustNormalize() *MWRateLimitOption {
+	util.AssertOk(o != nil, `option为空`)
+
+	if _string.Empty(o.KeyPrefix) {
+		o.KeyPrefix = `rl:grpc:`
+	}
+
+	if _string.Empty(o.KeyBy) {
+		o.KeyBy = RateLimitKeyByIP
+	}
+
+	for _, rule := range o.Rules {
+		util.AssertOk(rule != nil, `rule为空`)
+		util.AssertNotEmpty(rule.Method, `Method为空`)
+
+		if _string.Empty(rule.KeyBy) {
+			rule.KeyBy = o.KeyBy
+		}
+
+		mustCheckRateLimitKeyBy(rule.KeyBy)
+	}
+
+	mustCheckRateLimitKeyBy(o.KeyBy)
+	return o
+}
+
+func mustCheckRateLimitKeyBy(keyBy string) {
+	switch keyBy {
+	case RateLimitKeyByIP, RateLimitKeyByAuth, RateLimitKeyByMethod:
+	default:
+		panic(util.NewAssertFailError(`无效KeyBy[%v]`, keyBy))
+	}
+}
+
+// MWRateLimitKeyFn 回调函数，返回限流key。返回空字符串表示不限流
+type MWRateLimitKeyFn func(ctx context.Context, method, keyBy string) string
+
+type mwRateLimitEntry struct {
+	method  string
+	keyBy   string
+	limiter *rdb.Limiter
+}
+
+// MWRateLimit 限流中间件，仅服务端拦截器限流，超过限制将返回codes.ResourceExhausted
+// 响应头：x-ratelimit-limit/x-ratelimit-remaining/x-ratelimit-reset(秒)，被拒绝时响应头retry-after(秒)
+type MWRateLimit struct {
+	option   *MWRateLimitOption
+	keyFn    MWRateLimitKeyFn
+	fallback *mwRateLimitEntry
+	entries  []*mwRateLimitEntry //按方法名称长度倒序排列
+}
+
+func NewMWRateLimit(option *MWRateLimitOption, client redis.UniversalClient) *MWRateLimit {
+	o := option.MustNormalize()
+	m := &MWRateLimit{option: o}
+
+	if o.Default != nil {
+		m.fallback = &mwRateLimitEntry{
+			keyBy:   o.KeyBy,
+			limiter: rdb.MustNewLimiter(o.KeyPrefix+`default:`, o.Default, client),
+		}
+	}
+
+	methods := make(map[string]bool)
+	for _, rule := range o.Rules {
+		util.AssertOk(!methods[rule.Method], `方法限流配置重复[%v]`, rule.Method)
+		methods[rule.Method] = true
+
+		option := rule.LimiterOption
+		m.entries = append(m.entries, &mwRateLimitEntry{
+			method:  rule.Method,
+			keyBy:   rule.KeyBy,
+			limiter: rdb.MustNewLimiter(o.KeyPrefix+rule.Method+`:`, &option, client),
+		})
+	}
+
+	sort.Slice(m.entries, func(i, j int) bool {
+		return len(m.entries[i].method) > len(m.entries[j].method)
+	})
+
+	return m
+}
+
+// MustNewMWRateLimitFromCfgFile 读取配置文件创建限流中间件，如：conf/ratelimit_grpc.toml
+func MustNewMWRateLimitFromCfgFile(file string, client redis.UniversalClient) *MWRateLimit {
+	option := &MWRateLimitOption{}
+	conf.MustLoad(option, file)
+	return NewMWRateLimit(option, client)
+}
+
+// WithKeyFn 设置自定义限流key回调函数
+func (m *MWRateLimit) WithKeyFn(fn MWRateLimitKeyFn) *MWRateLimit {
+	m.keyFn = fn
+	return m
+}
+
+func (m *MWRateLimit) entry(method string) *mwRateLimitEntry {
+	for _, e := range m.entries {
+		if strings.HasPrefix(method, e.method) {
+			return e
+		}
+	}
+
+	return m.fallback
+}
+
+func (m *MWRateLimit) key(ctx context.Context, method, keyBy string) string {
+	if m.keyFn != nil {
+		return m.keyFn(ctx, method, keyBy)
+	}
+
+	switch keyBy {
+	case RateLimitKeyByMethod:
+		return method
+	case RateLimitKeyByAuth:
+		if v := AuthValueFromContext(ctx); v != nil {
+			return fmt.Sprintf(`u:%v`, v)
+		}
+	}
+
+	return `ip:` + clientIPFromContext(ctx)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ``
+	}
+
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+func (m *MWRateLimit) handle(ctx context.Context, method string) error {
+	if m.option.Disabled || strings.HasPrefix(method, `/grpc.health.`) {
+		return nil
+	}
+
+	e := m.entry(method)
+	if e == nil {
+		return nil
+	}
+
+	key := m.key(ctx, method, e.keyBy)
+	if key == `` {
+		return nil
+	}
+
+	rs, err := e.limiter.Allow(ctx, key)
+	if err != nil && !rs.Allowed {
+		return status.Errorf(codes.Unavailable, `rate limit check err->%v`, err)
+	}
+
+	if err == nil {
+		_ = grpc.SetHeader(ctx, rateLimitMD(rs))
+	}
+
+	if !rs.Allowed {
+		return ToRpcErr(util.NewTooOftenError(`请求过于频繁[method=%v]`, method))
+	}
+
+	return nil
+}
+
+func rateLimitMD(rs *rdb.LimitResult) metadata.MD {
+	md := metadata.Pairs(
+		`x-ratelimit-limit`, strconv.FormatInt(rs.Limit, 10),
+		`x-ratelimit-remaining`, strconv.FormatInt(rs.Remaining, 10),
+		`x-ratelimit-reset`, strconv.FormatInt(int64(math.Ceil(rs.ResetAfter.Seconds())), 10),
+	)
+
+	if !rs.Allowed && rs.RetryAfter > 0 {
+		md.Set(`retry-after`, strconv.FormatInt(int64(math.Ceil(rs.RetryAfter.Seconds())), 10))
+	}
+
+	return md
+}
+
+func (m *MWRateLimit) NewUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (m *MWRateLimit) NewStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (m *MWRateLimit) NewUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if err = m.handle(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (m *MWRateLimit) NewStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		if err = m.handle(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
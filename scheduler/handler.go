@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	bhttp "github.com/bingooh/b-go-util/http"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// RegisterHandlers 注册任务管理接口，如：s.RegisterHandlers(router.Group(`/admin/scheduler`))
+//
+//	GET  /tasks              查询全部任务信息
+//	GET  /tasks/:name        查询任务信息
+//	POST /tasks/:name/run    立即执行1次任务
+//	POST /tasks/:name/pause  暂停任务
+//	POST /tasks/:name/resume 恢复任务
+func (s *Scheduler) RegisterHandlers(r gin.IRouter) {
+	r.GET(`/tasks`, s.handleListTasks)
+	r.GET(`/tasks/:name`, s.withTask(s.handleGetTask))
+	r.POST(`/tasks/:name/run`, s.withTask(s.handleControl(s.RunNow)))
+	r.POST(`/tasks/:name/pause`, s.withTask(s.handleControl(s.Pause)))
+	r.POST(`/tasks/:name/resume`, s.withTask(s.handleControl(s.Resume)))
+}
+
+func abortWithNotFound(c *gin.Context, name string) {
+	err := bhttp.NewError(http.StatusNotFound, util.ErrCodeNotFound, `任务不存在[%v]`, name)
+	c.AbortWithStatusJSON(err.Status(), err)
+}
+
+// 检查任务是否存在
+func (s *Scheduler) withTask(fn func(c *gin.Context, name string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param(`name`)
+		if !s.HasTask(name) {
+			abortWithNotFound(c, name)
+			return
+		}
+
+		fn(c, name)
+	}
+}
+
+func (s *Scheduler) handleListTasks(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ListTasks())
+}
+
+func (s *Scheduler) handleGetTask(c *gin.Context, name string) {
+	rs, err := s.GetTask(name)
+	if err != nil {
+		abortWithNotFound(c, name)
+		return
+	}
+
+	c.JSON(http.StatusOK, rs)
+}
+
+func (s *Scheduler) handleControl(fn func(name string) error) func(c *gin.Context, name string) {
+	return func(c *gin.Context, name string) {
+		//任务仅在并发删除时不存在
+		if err := fn(name); err != nil {
+			abortWithNotFound(c, name)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
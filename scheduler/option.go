@@ -1,6 +1,9 @@
 package scheduler
 
-import "github.com/bingooh/b-go-util/util"
+import (
+	"github.com/bingooh/b-go-util/util"
+	"time"
+)
 
 type Option struct {
 	EnableCronSeconds bool                   //是否启用秒定时设置
@@ -13,6 +16,8 @@ type TaskOption struct {
 	EnableRecover       bool   //任务执行崩溃后是否恢复
 	SkipIfStillRunning  bool   //任务正在执行是否跳过本次触发。如果设置为true，则DelayIfStillRunning设置无效
 	DelayIfStillRunning bool   //任务正在执行是否延迟本次触发
+
+	Timeout time.Duration //每次执行超时时长，超时后取消任务ctx，仅适用于TaskFn任务，默认不超时
 }
 
 func (o *Option) MustNormalize() *Option {
@@ -12,6 +12,9 @@ import (
 
 var DefaultScheduler *Scheduler
 
+// TaskFn 可取消的任务，调用Stop()或执行超时将取消ctx，任务应尽快返回
+type TaskFn func(ctx context.Context) error
+
 func MustGetDefaultScheduler() *Scheduler {
 	util.AssertOk(DefaultScheduler != nil, `defaultScheduler为空`)
 	return DefaultScheduler
@@ -32,7 +35,9 @@ type Scheduler struct {
 
 	cr         *cron.Cron
 	cronLogger cron.Logger
-	tasks      map[string]cron.EntryID //key为任务名称
+	tasks      map[string]*task        //key为任务名称
+	rootCtx    *util.CancelableContext //任务ctx的父ctx，调用Stop()时取消
+	runNowWG   sync.WaitGroup          //RunNow()执行中的任务，调用Stop()时等待
 }
 
 // 读取默认配置文件scheduler.toml创建Scheduler
@@ -47,7 +52,8 @@ func MustNewScheduler(option *Option) *Scheduler {
 		option:     option.MustNormalize(),
 		logger:     newSchedulerZapLogger(),
 		cronLogger: newSchedulerLogger(),
-		tasks:      make(map[string]cron.EntryID),
+		tasks:      make(map[string]*task),
+		rootCtx:    util.NewCancelableContext(),
 	}
 
 	opts := []cron.Option{cron.WithLogger(s.cronLogger)}
@@ -75,10 +81,56 @@ func (s *Scheduler) MustAddTaskFn(name string, task func()) {
 	s.MustAddTask(name, cron.FuncJob(task))
 }
 
+// MustAddContextTaskFn 添加可取消的任务，任务出错将记录日志。设置TaskOption.Timeout则每次执行超时后取消ctx
+func (s *Scheduler) MustAddContextTaskFn(name string, task TaskFn) {
+	util.AssertOk(task != nil, `task为空`)
+
+	opt := s.option.Task(name)
+	util.AssertOk(opt != nil, `任务配置[%v]不存在`, name)
+
+	s.mustAddTask(name, func() error {
+		return s.runTaskFn(name, opt, task)
+	})
+}
+
+func (s *Scheduler) runTaskFn(name string, opt *TaskOption, task TaskFn) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if opt.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(s.rootContext(), opt.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(s.rootContext())
+	}
+	defer cancel()
+
+	start := time.Now()
+	err := task(ctx)
+	if err != nil {
+		s.logger.Error(`任务执行出错`, zap.String(`task`, name), zap.Duration(`duration`, time.Since(start)), zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *Scheduler) rootContext() context.Context {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.rootCtx.Context()
+}
+
 func (s *Scheduler) MustAddTask(name string, task cron.Job) {
-	util.AssertNotEmpty(name, `name为空`)
 	util.AssertOk(task != nil, `task为空`)
 
+	s.mustAddTask(name, func() error {
+		task.Run()
+		return nil
+	})
+}
+
+func (s *Scheduler) mustAddTask(name string, fn func() error) {
+	util.AssertNotEmpty(name, `name为空`)
+
 	opt := s.option.Task(name)
 	util.AssertOk(opt != nil, `任务配置[%v]不存在`, name)
 
@@ -89,21 +141,32 @@ func (s *Scheduler) MustAddTask(name string, task cron.Job) {
 	util.AssertOk(!exist, `任务[%v]已存在`, name)
 
 	//每个job单独定义调用链，未使用全局定义
-	//var job cron.Job = cron.FuncJob(task)
+	t := newTask(name, opt)
+	var job cron.Job = cron.FuncJob(func() {
+		t.run(fn)
+	})
+
 	if opt.EnableRecover {
-		task = cron.Recover(s.cronLogger)(task)
+		job = cron.Recover(s.cronLogger)(job)
 	}
 
 	if opt.SkipIfStillRunning {
-		task = cron.SkipIfStillRunning(s.cronLogger)(task)
+		job = cron.SkipIfStillRunning(s.cronLogger)(job)
 	} else if opt.DelayIfStillRunning {
-		task = cron.DelayIfStillRunning(s.cronLogger)(task)
+		job = cron.DelayIfStillRunning(s.cronLogger)(job)
 	}
 
-	id, err := s.cr.AddJob(opt.Cron, task)
+	//暂停仅跳过定时触发，RunNow()仍可执行
+	t.job = job
+	id, err := s.cr.AddJob(opt.Cron, cron.FuncJob(func() {
+		if t.paused.False() {
+			t.job.Run()
+		}
+	}))
 	util.AssertNilErr(err, `新增任务[%v]出错`, name)
 
-	s.tasks[name] = id
+	t.id = id
+	s.tasks[name] = t
 	s.logger.Sugar().Infof(`新增任务[%v]`, name)
 }
 
@@ -112,8 +175,8 @@ func (s *Scheduler) RemoveTasks(names ...string) {
 	defer s.lock.Unlock()
 
 	for _, name := range names {
-		if id, ok := s.tasks[name]; ok {
-			s.cr.Remove(id)
+		if t, ok := s.tasks[name]; ok {
+			s.cr.Remove(t.id)
 			delete(s.tasks, name)
 			s.logger.Sugar().Infof(`删除任务[%v]`, name)
 		}
@@ -125,18 +188,37 @@ func (s *Scheduler) Start() {
 		return
 	}
 
+	s.lock.Lock()
+	if s.rootCtx.Context().Err() != nil {
+		s.rootCtx = util.NewCancelableContext()
+	}
+	s.lock.Unlock()
+
 	s.cr.Start()
 	s.logger.Info(`scheduler已启动`)
 }
 
-// 此方法会阻塞当前线程，直到超时或所有任务停止执行
+// 此方法会阻塞当前线程，直到超时或所有任务停止执行，包括RunNow()执行中的任务
+// 停止调度后先取消执行中的TaskFn任务ctx，再等待任务停止执行。超时则输出仍在执行的任务
 func (s *Scheduler) Stop(timeout time.Duration) {
 	if s == nil {
 		return
 	}
 
+	stopCtx := s.cr.Stop()
+	s.lock.Lock()
+	s.rootCtx.Cancel()
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		<-stopCtx.Done()
+		s.runNowWG.Wait()
+		close(done)
+	}()
+
 	if timeout <= 0 {
-		<-s.cr.Stop().Done()
+		<-done
 		s.logger.Info(`scheduler已停止`)
 		return
 	}
@@ -145,9 +227,9 @@ func (s *Scheduler) Stop(timeout time.Duration) {
 	defer cancel()
 
 	select {
-	case <-s.cr.Stop().Done():
+	case <-done:
 		s.logger.Info(`scheduler已停止`)
 	case <-ctx.Done():
-		s.logger.Warn(`scheduler停止超时`)
+		s.logger.Warn(`scheduler停止超时`, zap.Strings(`running`, s.runningTaskNames()))
 	}
 }
@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskInfo 任务信息
+type TaskInfo struct {
+	Name         string        `json:"name"`
+	Cron         string        `json:"cron"`
+	Paused       bool          `json:"paused"`
+	Running      bool          `json:"running"`
+	NextRunTime  time.Time     `json:"next_run_time"`        //下次定时触发时间
+	PrevRunTime  time.Time     `json:"prev_run_time"`        //上次定时触发时间，不包括RunNow()
+	LastRunTime  time.Time     `json:"last_run_time"`        //上次执行开始时间，包括RunNow()
+	LastDuration time.Duration `json:"last_duration"`        //上次执行耗时
+	LastError    string        `json:"last_error,omitempty"` //上次执行错误，执行成功则为空
+	RunCount     int64         `json:"run_count"`
+	ErrorCount   int64         `json:"error_count"` //执行出错或崩溃次数
+}
+
+type task struct {
+	name    string
+	option  *TaskOption
+	id      cron.EntryID
+	job     cron.Job //已添加调用链的任务
+	paused  *util.AtomicBool
+	running *util.AtomicInt64 //执行中的数量，DelayIfStillRunning等未限制并发时可能大于1
+
+	mu           sync.Mutex //保护以下执行统计
+	lastRunTime  time.Time
+	lastDuration time.Duration
+	lastError    string
+	runCount     int64
+	errorCount   int64
+}
+
+func newTask(name string, option *TaskOption) *task {
+	return &task{
+		name:    name,
+		option:  option,
+		paused:  util.NewAtomicBool(false),
+		running: util.NewAtomicInt64(0),
+	}
+}
+
+// 执行任务并记录执行统计，任务崩溃时记录后继续抛出，由调用链决定是否恢复
+func (t *task) run(fn func() error) {
+	start := time.Now()
+	t.running.Incr(1)
+
+	var err error
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = fmt.Errorf(`panic: %v`, r)
+		}
+
+		t.running.Incr(-1)
+		t.record(start, err)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	err = fn()
+}
+
+func (t *task) record(start time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastRunTime = start
+	t.lastDuration = time.Since(start)
+	t.lastError = ``
+	t.runCount++
+
+	if err != nil {
+		t.lastError = err.Error()
+		t.errorCount++
+	}
+}
+
+func (t *task) info(entry cron.Entry) *TaskInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return &TaskInfo{
+		Name:         t.name,
+		Cron:         t.option.Cron,
+		Paused:       t.paused.True(),
+		Running:      t.running.Value() > 0,
+		NextRunTime:  entry.Next,
+		PrevRunTime:  entry.Prev,
+		LastRunTime:  t.lastRunTime,
+		LastDuration: t.lastDuration,
+		LastError:    t.lastError,
+		RunCount:     t.runCount,
+		ErrorCount:   t.errorCount,
+	}
+}
+
+func (s *Scheduler) getTask(name string) (*task, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if t, ok := s.tasks[name]; ok {
+		return t, nil
+	}
+
+	return nil, util.NewNotFoundError(`任务[%v]不存在`, name)
+}
+
+// ListTasks 查询全部已添加任务的信息，按任务名称排序
+func (s *Scheduler) ListTasks() []*TaskInfo {
+	s.lock.Lock()
+	tasks := make([]*task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, t)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].name < tasks[j].name
+	})
+
+	rs := make([]*TaskInfo, 0, len(tasks))
+	for _, t := range tasks {
+		rs = append(rs, t.info(s.cr.Entry(t.id)))
+	}
+
+	return rs
+}
+
+// GetTask 查询任务信息，任务不存在返回NotFoundError
+func (s *Scheduler) GetTask(name string) (*TaskInfo, error) {
+	t, err := s.getTask(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.info(s.cr.Entry(t.id)), nil
+}
+
+// 执行中的任务名称，按名称排序
+func (s *Scheduler) runningTaskNames() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var names []string
+	for name, t := range s.tasks {
+		if t.running.Value() > 0 {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// RunNow 立即异步执行1次任务，不受暂停限制，仍然应用任务调用链，如：SkipIfStillRunning
+// 调用Stop()将等待执行结束，Stop()之后调用返回IllegalStateError。任务崩溃仅记录日志，不受EnableRecover影响
+func (s *Scheduler) RunNow(name string) error {
+	t, err := s.getTask(name)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rootCtx.Context().Err() != nil {
+		return util.NewIllegalStateError(`scheduler已停止`)
+	}
+
+	s.runNowWG.Add(1)
+	go func() {
+		defer s.runNowWG.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				s.logger.Error(`任务崩溃`, zap.String(`task`, name), zap.Any(`panic`, r))
+			}
+		}()
+
+		t.job.Run()
+	}()
+
+	return nil
+}
+
+// Pause 暂停任务，暂停期间跳过定时触发，执行中的任务不受影响
+func (s *Scheduler) Pause(name string) error {
+	t, err := s.getTask(name)
+	if err != nil {
+		return err
+	}
+
+	t.paused.Set(true)
+	s.logger.Sugar().Infof(`暂停任务[%v]`, name)
+	return nil
+}
+
+// Resume 恢复已暂停的任务
+func (s *Scheduler) Resume(name string) error {
+	t, err := s.getTask(name)
+	if err != nil {
+		return err
+	}
+
+	t.paused.Set(false)
+	s.logger.Sugar().Infof(`恢复任务[%v]`, name)
+	return nil
+}
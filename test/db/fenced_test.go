@@ -0,0 +1,28 @@
+package db
+
+import (
+	"github.com/bingooh/b-go-util/orm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestUpdateFenced(t *testing.T) {
+	r := require.New(t)
+
+	pool := &fakeSegmentPool{rows: make(map[string]int64)}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: pool, SkipInitializeWithVersion: true}), &gorm.Config{DryRun: true})
+	r.NoError(err)
+
+	//防护令牌列为NULL的记录也可更新
+	var sql string
+	r.NoError(db.Callback().Update().After(`gorm:update`).Register(`test:fenced_sql`, func(db *gorm.DB) {
+		sql = db.Statement.SQL.String()
+	}))
+
+	_, err = orm.UpdateFenced(db.Table(`account`).Where(`id=?`, 1), `fencing_token`, 5, map[string]interface{}{`balance`: 100})
+	r.NoError(err)
+	r.Contains(sql, `COALESCE(fencing_token, 0) <= ?`)
+	r.Contains(sql, "`fencing_token`=?")
+}
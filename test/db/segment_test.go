@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/bingooh/b-go-util/orm"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 模拟号段表的连接池，仅支持SegmentStore.Save()执行的UPDATE/INSERT语句
+type fakeSegmentPool struct {
+	mu   sync.Mutex
+	rows map[string]int64 //key为name，值为max_id
+	sqls []string
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+func (p *fakeSegmentPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sqls = append(p.sqls, query)
+	switch {
+	case strings.HasPrefix(query, `UPDATE`): //SET max_id=?,updated_at=? WHERE name=? AND max_id<?
+		name, maxID := args[2].(string), args[0].(int64)
+		if old, ok := p.rows[name]; ok && old < maxID {
+			p.rows[name] = maxID
+			return fakeResult(1), nil
+		}
+		return fakeResult(0), nil
+	case strings.HasPrefix(query, `INSERT`): //(name,max_id,updated_at)
+		name, maxID := args[0].(string), args[1].(int64)
+		if _, ok := p.rows[name]; ok {
+			return fakeResult(0), nil
+		}
+		p.rows[name] = maxID
+		return fakeResult(1), nil
+	}
+
+	return nil, errors.New(`unsupported sql: ` + query)
+}
+
+func (p *fakeSegmentPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New(`unsupported`)
+}
+
+func (p *fakeSegmentPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New(`unsupported`)
+}
+
+func (p *fakeSegmentPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestSegmentStoreSave(t *testing.T) {
+	r := require.New(t)
+
+	pool := &fakeSegmentPool{rows: make(map[string]int64)}
+	db, err := gorm.Open(mysql.New(mysql.Config{Conn: pool, SkipInitializeWithVersion: true}), &gorm.Config{})
+	r.NoError(err)
+
+	ctx := context.Background()
+	store := orm.NewSegmentStore(db, ``)
+
+	//新号段先更新再插入
+	r.NoError(store.Save(ctx, `order`, 100))
+	r.EqualValues(100, pool.rows[`order`])
+	r.Len(pool.sqls, 2)
+	r.True(strings.HasPrefix(pool.sqls[1], "INSERT INTO `id_segment`"))
+	r.NotContains(pool.sqls[1], `WHERE`)
+
+	//更大的maxID仅更新
+	r.NoError(store.Save(ctx, `order`, 200))
+	r.EqualValues(200, pool.rows[`order`])
+	r.Len(pool.sqls, 3)
+	r.True(strings.HasPrefix(pool.sqls[2], "UPDATE `id_segment`"))
+
+	//更小的maxID不更新
+	r.NoError(store.Save(ctx, `order`, 150))
+	r.EqualValues(200, pool.rows[`order`])
+}
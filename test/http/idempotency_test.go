@@ -0,0 +1,88 @@
+package http
+
+import (
+	"github.com/bingooh/b-go-util/http"
+	"github.com/bingooh/b-go-util/internal/rdbtest"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	r := require.New(t)
+
+	store := rdb.MustNewIdempotencyStore(&rdb.IdempotencyStoreOption{}, rdbtest.MustNewClient(t))
+
+	var count int32
+	g := gin.New()
+	g.Use(gin.Recovery())
+	g.Use(http.MWIdempotency(store, &http.MWIdempotencyOption{Required: true}))
+	g.POST(`/pay`, func(c *gin.Context) {
+		n := atomic.AddInt32(&count, 1)
+		if c.Query(`slow`) != `` {
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		if c.Query(`panic`) != `` {
+			panic(`pay panic`)
+		}
+
+		if c.Query(`fail`) != `` {
+			c.JSON(500, `fail`)
+			return
+		}
+
+		c.JSON(201, n)
+	})
+
+	doPost := func(key, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(`POST`, `/pay`+query, nil)
+		if key != `` {
+			req.Header.Set(`Idempotency-Key`, key)
+		}
+
+		g.ServeHTTP(w, req)
+		return w
+	}
+
+	r.Equal(400, doPost(``, ``).Code)
+
+	//重复请求重放首次请求的响应
+	w := doPost(`k1`, ``)
+	r.Equal(201, w.Code)
+	r.Equal(`1`, w.Body.String())
+
+	w = doPost(`k1`, ``)
+	r.Equal(201, w.Code)
+	r.Equal(`1`, w.Body.String())
+	r.Equal(`true`, w.Header().Get(`Idempotent-Replayed`))
+	r.EqualValues(1, atomic.LoadInt32(&count))
+
+	//首次请求处理中
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Equal(201, doPost(`k2`, `?slow=1`).Code)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	r.Equal(409, doPost(`k2`, ``).Code)
+	<-done
+
+	//处理失败允许重试
+	r.Equal(500, doPost(`k3`, `?fail=1`).Code)
+	w = doPost(`k3`, ``)
+	r.Equal(201, w.Code)
+	r.Empty(w.Header().Get(`Idempotent-Replayed`))
+
+	//处理崩溃允许重试
+	r.Equal(500, doPost(`k4`, `?panic=1`).Code)
+	w = doPost(`k4`, ``)
+	r.Equal(201, w.Code)
+	r.Empty(w.Header().Get(`Idempotent-Replayed`))
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"github.com/bingooh/b-go-util/http"
+	"github.com/bingooh/b-go-util/internal/rdbtest"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	r := require.New(t)
+
+	client := rdbtest.MustNewClient(t)
+
+	//读取配置文件conf/ratelimit_http.toml
+	g := gin.New()
+	g.Use(http.MustNewMWRateLimitFromCfgFile(`ratelimit_http`, client))
+	g.GET(`/limit`, func(c *gin.Context) {
+		c.JSON(200, `ok`)
+	})
+
+	doGet := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(`GET`, `/limit`, nil))
+		return w
+	}
+
+	//每秒最多请求2次
+	for i := 0; i < 2; i++ {
+		w := doGet()
+		r.Equal(200, w.Code)
+		r.Equal(`2`, w.Header().Get(`X-RateLimit-Limit`))
+	}
+
+	w := doGet()
+	r.Equal(429, w.Code)
+	r.Equal(`0`, w.Header().Get(`X-RateLimit-Remaining`))
+	r.Equal(`1`, w.Header().Get(`Retry-After`))
+
+	time.Sleep(1 * time.Second)
+	r.Equal(200, doGet().Code)
+}
@@ -0,0 +1,110 @@
+package rdb
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBloomFilter(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	bf := rdb.MustNewBloomFilter(`test.bloom`, 1000, 0.01, client)
+	r.EqualValues(9586, bf.Bits())
+	r.EqualValues(7, bf.Hashes())
+	r.NoError(bf.Reset(ctx))
+
+	var items []string
+	for i := 0; i < 1000; i++ {
+		items = append(items, fmt.Sprintf(`item-%v`, i))
+	}
+	r.NoError(bf.Add(ctx, items...))
+
+	//已添加的元素一定返回true
+	rs, err := bf.MayContain(ctx, items...)
+	r.NoError(err)
+	for _, ok := range rs {
+		r.True(ok)
+	}
+
+	//误判率约1%
+	var others []string
+	for i := 0; i < 1000; i++ {
+		others = append(others, fmt.Sprintf(`other-%v`, i))
+	}
+
+	rs, err = bf.MayContain(ctx, others...)
+	r.NoError(err)
+	fp := 0
+	for _, ok := range rs {
+		if ok {
+			fp++
+		}
+	}
+	r.True(fp < 30, fp)
+
+	//不在过滤器里的key不查询后端
+	loadCount := 0
+	cache := rdb.NewCache(&rdb.CacheOption{}, client, func(ctx context.Context, key string) (string, error) {
+		loadCount++
+		return `v`, nil
+	}).WithBloomFilter(bf)
+
+	r.NoError(cache.Del(ctx, `item-1`))
+	v, err := cache.FetchNew(ctx, `item-1`, 1*time.Second)
+	r.NoError(err)
+	r.Equal(`v`, v)
+
+	v, err = cache.FetchNew(ctx, `none`, 1*time.Second)
+	r.NoError(err)
+	r.Empty(v)
+	r.Equal(1, loadCount)
+	r.NoError(cache.Del(ctx, `item-1`))
+}
+
+func TestHyperLogLog(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	day1 := rdb.NewHyperLogLog(`{test.uv}:d1`, client)
+	day2 := rdb.NewHyperLogLog(`{test.uv}:d2`, client)
+	week := rdb.NewHyperLogLog(`{test.uv}:w`, client)
+	for _, h := range []*rdb.HyperLogLog{day1, day2, week} {
+		r.NoError(h.Reset(ctx))
+	}
+
+	ok, err := day1.Add(ctx, `u1`, `u2`, `u3`)
+	r.NoError(err)
+	r.True(ok)
+
+	ok, err = day1.Add(ctx, `u1`)
+	r.NoError(err)
+	r.False(ok)
+
+	_, err = day2.Add(ctx, `u3`, `u4`)
+	r.NoError(err)
+
+	n, err := day1.Count(ctx)
+	r.NoError(err)
+	r.EqualValues(3, n)
+
+	r.NoError(week.Merge(ctx, day1.Key(), day2.Key()))
+	n, err = week.Count(ctx)
+	r.NoError(err)
+	r.EqualValues(4, n)
+
+	//内存redis的PFCOUNT多个key时返回各key基数之和，不是并集基数
+	if memRedis {
+		t.Skip(`内存redis不支持PFCOUNT多个key，设置REDIS_ADDR后测试CountUnion`)
+	}
+
+	n, err = day1.CountUnion(ctx, day2.Key())
+	r.NoError(err)
+	r.EqualValues(4, n)
+}
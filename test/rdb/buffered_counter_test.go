@@ -0,0 +1,79 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/async"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBufferedCounter(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+	client.Del(ctx, `test_bc_pv`, `test_bc_api`)
+
+	counter := rdb.MustNewBufferedCounter(&rdb.BufferedCounterOption{
+		Ticker: async.NewTickerOption(0, 5, 200*time.Millisecond),
+		TTL:    time.Minute,
+	}, client)
+
+	for i := 0; i < 3; i++ {
+		counter.Incr(`test_bc_pv`, 1)
+		counter.HIncr(`test_bc_api`, `get`, 2)
+	}
+
+	//累计6次，超过MaxCount=5立即刷新
+	time.Sleep(50 * time.Millisecond)
+	r.Equal(`3`, client.Get(ctx, `test_bc_pv`).Val())
+	r.Equal(`6`, client.HGet(ctx, `test_bc_api`, `get`).Val())
+	r.True(client.TTL(ctx, `test_bc_pv`).Val() > 0)
+
+	//未达到MaxCount，等待定时刷新
+	counter.Incr(`test_bc_pv`, 10)
+	n, err := counter.Get(ctx, `test_bc_pv`)
+	r.NoError(err)
+	r.EqualValues(13, n)
+	r.Equal(1, counter.Pending())
+
+	time.Sleep(300 * time.Millisecond)
+	r.Equal(0, counter.Pending())
+	r.Equal(`13`, client.Get(ctx, `test_bc_pv`).Val())
+
+	//关闭时刷新剩余增量
+	counter.HIncr(`test_bc_api`, `get`, 1)
+	r.NoError(counter.Close())
+	r.Equal(`7`, client.HGet(ctx, `test_bc_api`, `get`).Val())
+
+	counter.Incr(`test_bc_pv`, 1)
+	r.Equal(0, counter.Pending())
+}
+
+func TestBufferedCounterWrongType(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+	client.Del(ctx, `test_bc_hash`, `test_bc_ok`)
+	r.NoError(client.HSet(ctx, `test_bc_hash`, `f`, 1).Err())
+
+	var dropped []*rdb.CounterDelta
+	counter := rdb.MustNewBufferedCounter(&rdb.BufferedCounterOption{
+		Ticker: async.NewTickerOption(0, 1000, time.Hour),
+	}, client).WithOnFlushFailed(func(deltas []*rdb.CounterDelta, err error) {
+		r.Contains(err.Error(), `WRONGTYPE`)
+		dropped = append(dropped, deltas...)
+	})
+	defer counter.Close()
+
+	//key类型错误的增量不再重试，立即回调并丢弃
+	counter.Incr(`test_bc_hash`, 2)
+	counter.Incr(`test_bc_ok`, 3)
+	r.Error(counter.Flush(ctx))
+	r.Equal(0, counter.Pending())
+	r.Equal(`3`, client.Get(ctx, `test_bc_ok`).Val())
+	r.Equal([]*rdb.CounterDelta{{Key: `test_bc_hash`, Delta: 2}}, dropped)
+}
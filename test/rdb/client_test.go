@@ -0,0 +1,53 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/conf"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRedisOption(t *testing.T) {
+	r := require.New(t)
+
+	o := (&rdb.Option{}).MustNormalize()
+	r.Equal(rdb.ModeStandalone, o.Mode)
+	r.Equal([]string{`localhost:6379`}, o.Addrs)
+	r.Equal(100*time.Millisecond, o.Log.SlowThreshold)
+
+	o = (&rdb.Option{MasterName: `master`, Addrs: []string{`a:26379`, `b:26379`}}).MustNormalize()
+	r.Equal(rdb.ModeSentinel, o.Mode)
+
+	o = (&rdb.Option{Addrs: []string{`a:7000`, `b:7000`}}).MustNormalize()
+	r.Equal(rdb.ModeCluster, o.Mode)
+
+	r.Panics(func() { (&rdb.Option{Mode: rdb.ModeSentinel}).MustNormalize() })
+	r.Panics(func() { (&rdb.Option{Mode: `x`}).MustNormalize() })
+}
+
+func TestDefaultClient(t *testing.T) {
+	r := require.New(t)
+
+	option := &rdb.Option{}
+	conf.MustLoad(option, `redis`)
+	r.Equal(3, option.DB)
+	r.Equal(10, option.Pool.PoolSize)
+	r.Equal(10*time.Millisecond, option.Log.SlowThreshold)
+
+	//默认客户端连接配置文件redis.toml指定的redis
+	if memRedis {
+		t.Skip(`需要真实redis，设置REDIS_ADDR后测试`)
+	}
+
+	client := rdb.MustInitDefaultClient()
+	defer rdb.CloseDefaultClient()
+	r.Equal(client, rdb.MustGetDefaultClient())
+
+	ctx := context.Background()
+	r.NoError(client.Set(ctx, `test_client`, 1, time.Minute).Err())
+	r.Equal(`1`, client.Get(ctx, `test_client`).Val())
+	r.Equal(redis.Nil, client.Get(ctx, `test_client_none`).Err())
+}
@@ -0,0 +1,116 @@
+package rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayQueue(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, `dq:{test}:delayed`, `dq:{test}:ready`, `dq:{test}:reserved`,
+		`dq:{test}:dead`, `dq:{test}:jobs`, `dq:{test}:attempts`).Err())
+
+	q := rdb.MustNewDelayQueue(&rdb.DelayQueueOption{
+		Name:              `test`,
+		VisibilityTimeout: 1 * time.Second,
+		MaxAttempts:       2,
+		RetryBackoff:      100 * time.Millisecond,
+	}, client)
+
+	//任务1秒后才可执行
+	id, err := q.Enqueue(ctx, `a`, 1*time.Second)
+	r.NoError(err)
+
+	job, err := q.Reserve(ctx)
+	r.NoError(err)
+	r.Nil(job)
+
+	time.Sleep(1100 * time.Millisecond)
+	job, err = q.Reserve(ctx)
+	r.NoError(err)
+	r.Equal(id, job.ID)
+	r.Equal(`a`, job.Payload)
+	r.EqualValues(1, job.Attempts)
+
+	//可见性超时后重新投递
+	time.Sleep(1100 * time.Millisecond)
+	n, err := q.Promote(ctx)
+	r.NoError(err)
+	r.EqualValues(1, n)
+
+	ok, err := q.Ack(ctx, job.ID)
+	r.NoError(err)
+	r.False(ok)
+
+	job, err = q.Reserve(ctx)
+	r.NoError(err)
+	r.Equal(id, job.ID)
+	r.EqualValues(2, job.Attempts)
+
+	//执行次数达到上限，转移到死信队列
+	ok, err = q.Nack(ctx, job)
+	r.NoError(err)
+	r.True(ok)
+
+	stats, err := q.Stats(ctx)
+	r.NoError(err)
+	r.Equal(rdb.DelayQueueStats{Dead: 1}, *stats)
+
+	jobs, err := q.DeadJobs(ctx, 0, 10)
+	r.NoError(err)
+	r.Len(jobs, 1)
+	r.Equal(`a`, jobs[0].Payload)
+
+	ok, err = q.RequeueDead(ctx, id)
+	r.NoError(err)
+	r.True(ok)
+
+	job, err = q.Reserve(ctx)
+	r.NoError(err)
+	r.EqualValues(1, job.Attempts)
+	ok, err = q.Ack(ctx, job.ID)
+	r.NoError(err)
+	r.True(ok)
+
+	stats, err = q.Stats(ctx)
+	r.NoError(err)
+	r.Equal(rdb.DelayQueueStats{}, *stats)
+
+	//消费者首次执行失败，重试后成功
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	consumer := q.NewConsumer(func(ctx context.Context, job *rdb.DelayJob) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		handled[job.Payload]++
+		if job.Attempts == 1 {
+			return errors.New(`fail`)
+		}
+		return nil
+	})
+
+	for _, p := range []string{`b`, `c`} {
+		_, err = q.Enqueue(ctx, p, 0)
+		r.NoError(err)
+	}
+
+	consumer.Start()
+	time.Sleep(3 * time.Second)
+	consumer.Stop()
+
+	mu.Lock()
+	r.Equal(map[string]int{`b`: 2, `c`: 2}, handled)
+	mu.Unlock()
+
+	stats, err = q.Stats(ctx)
+	r.NoError(err)
+	r.Equal(rdb.DelayQueueStats{}, *stats)
+}
@@ -0,0 +1,83 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	Name   string  `redis:"name"`
+	Age    int     `redis:"age"`
+	City   string  `redis:"city"`
+	Score  float64 `redis:"score"`
+	Active bool    `redis:"active"`
+	Remark string
+}
+
+func TestHashRepo(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	repo := rdb.MustNewHashRepo[testUser](&rdb.HashRepoOption{
+		KeyPrefix: `test_user:`,
+		TTL:       time.Minute,
+		Indexes:   []string{`city`},
+	}, client)
+
+	ctx := context.Background()
+	r.NoError(repo.Delete(ctx, `1`, `2`, `3`))
+
+	v, err := repo.Get(ctx, `1`)
+	r.NoError(err)
+	r.Nil(v)
+
+	u1 := &testUser{Name: `a`, Age: 10, City: `sz`, Score: 1.5, Active: true, Remark: `ignored`}
+	u2 := &testUser{Name: `b`, Age: 20, City: `gz`}
+	r.NoError(repo.SaveMany(ctx, map[string]*testUser{`1`: u1, `2`: u2}, 0))
+	r.True(client.TTL(ctx, repo.Key(`1`)).Val() > 0)
+
+	vs, err := repo.GetMany(ctx, `1`, `2`, `3`)
+	r.NoError(err)
+	r.Len(vs, 3)
+	r.Equal(`a`, vs[0].Name)
+	r.Equal(1.5, vs[0].Score)
+	r.True(vs[0].Active)
+	r.Empty(vs[0].Remark)
+	r.Equal(20, vs[1].Age)
+	r.Nil(vs[2])
+
+	//仅更新变化的字段，并更新索引
+	u3 := *u1
+	u3.City = `gz`
+	u3.Age = 11
+	fields, err := repo.Update(ctx, `1`, u1, &u3, 0)
+	r.NoError(err)
+	r.ElementsMatch([]string{`age`, `city`}, fields)
+
+	ids, err := repo.FindIDs(ctx, `city`, `sz`)
+	r.NoError(err)
+	r.Empty(ids)
+
+	items, err := repo.Find(ctx, `city`, `gz`)
+	r.NoError(err)
+	r.Len(items, 2)
+
+	n, err := repo.Incr(ctx, `1`, `age`, 2)
+	r.NoError(err)
+	r.EqualValues(13, n)
+
+	f, err := repo.IncrFloat(ctx, `1`, `score`, 0.5)
+	r.NoError(err)
+	r.Equal(2.0, f)
+
+	r.Panics(func() { repo.Incr(ctx, `1`, `name`, 1) })
+	r.Panics(func() { repo.FindIDs(ctx, `name`, `a`) })
+
+	r.NoError(repo.Delete(ctx, `1`))
+	ids, err = repo.FindIDs(ctx, `city`, `gz`)
+	r.NoError(err)
+	r.Equal([]string{`2`}, ids)
+}
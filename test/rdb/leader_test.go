@@ -0,0 +1,67 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElector(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, `leader:test`).Err())
+
+	var elected, revoked int32
+	newElector := func(id string) *rdb.LeaderElector {
+		return rdb.MustNewLeaderElector(&rdb.LeaderElectorOption{
+			Name:          `test`,
+			Identity:      id,
+			LeaseTTL:      1 * time.Second,
+			RetryInterval: 200 * time.Millisecond,
+		}, newRedisClient()).
+			WithOnElected(func(ctx context.Context) { atomic.AddInt32(&elected, 1) }).
+			WithOnRevoked(func() { atomic.AddInt32(&revoked, 1) })
+	}
+
+	e1, e2 := newElector(`e1`), newElector(`e2`)
+	e1.Start()
+	time.Sleep(100 * time.Millisecond)
+	e2.Start()
+	time.Sleep(2 * time.Second)
+
+	//e1先启动成为领导者，会话锁自动续约
+	r.True(e1.IsLeader())
+	r.False(e2.IsLeader())
+	leader, err := e2.Leader(ctx)
+	r.NoError(err)
+	r.Equal(`e1`, leader)
+
+	//e1主动放弃，e2成为领导者
+	r.NoError(e1.Resign(ctx))
+	time.Sleep(500 * time.Millisecond)
+	r.False(e1.IsLeader())
+	r.True(e2.IsLeader())
+
+	//模拟e2会话锁过期被其他实例抢占，续约失败后失去领导权
+	r.NoError(client.Set(ctx, `leader:test`, `other`, 1500*time.Millisecond).Err())
+	time.Sleep(1 * time.Second)
+	r.False(e2.IsLeader())
+
+	//锁过期后重新竞选
+	time.Sleep(2 * time.Second)
+	r.True(e1.IsLeader() || e2.IsLeader())
+
+	e1.Stop()
+	e2.Stop()
+	r.False(e1.IsLeader() || e2.IsLeader())
+
+	leader, err = e1.Leader(ctx)
+	r.NoError(err)
+	r.Empty(leader)
+	r.EqualValues(3, atomic.LoadInt32(&elected))
+	r.EqualValues(3, atomic.LoadInt32(&revoked))
+}
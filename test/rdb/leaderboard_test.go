@@ -0,0 +1,117 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLeaderboard(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	//总榜同分排序时间精确到分钟，使用日榜测试间隔1秒提交的同分排序
+	lb := rdb.MustNewLeaderboard(&rdb.LeaderboardOption{Name: `test_score`, Period: rdb.LeaderboardPeriodDaily, TieBreak: true}, client)
+	r.NoError(lb.Reset(ctx))
+
+	v, ok, err := lb.Submit(ctx, `a`, 100)
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(100.0, v)
+
+	//best模式下成绩未提高不更新
+	v, ok, err = lb.Submit(ctx, `a`, 90)
+	r.NoError(err)
+	r.False(ok)
+	r.Equal(100.0, v)
+
+	//同分时先提交的排名更高
+	time.Sleep(1100 * time.Millisecond)
+	_, _, err = lb.Submit(ctx, `b`, 100)
+	r.NoError(err)
+	_, _, err = lb.Submit(ctx, `c`, 200)
+	r.NoError(err)
+	_, _, err = lb.Submit(ctx, `d`, 50)
+	r.NoError(err)
+
+	top, err := lb.Top(ctx, 0, 3)
+	r.NoError(err)
+	r.Len(top, 3)
+	r.Equal(`c`, top[0].Member)
+	r.Equal(`a`, top[1].Member)
+	r.Equal(`b`, top[2].Member)
+	r.Equal(100.0, top[2].Score)
+	r.EqualValues(3, top[2].Rank)
+
+	e, err := lb.Get(ctx, `d`)
+	r.NoError(err)
+	r.EqualValues(4, e.Rank)
+	r.Equal(50.0, e.Score)
+
+	e, err = lb.Get(ctx, `none`)
+	r.NoError(err)
+	r.Nil(e)
+
+	around, err := lb.Around(ctx, `a`, 1)
+	r.NoError(err)
+	r.Len(around, 3)
+	r.Equal(`c`, around[0].Member)
+	r.Equal(`b`, around[2].Member)
+
+	//日榜累加成绩并自动过期
+	daily := rdb.MustNewLeaderboard(&rdb.LeaderboardOption{
+		Name:   `test_sales`,
+		Mode:   rdb.LeaderboardModeSum,
+		Period: rdb.LeaderboardPeriodDaily,
+	}, client)
+	r.NoError(daily.Reset(ctx))
+
+	_, _, err = daily.Submit(ctx, `x`, 1.5)
+	r.NoError(err)
+	v, _, err = daily.Submit(ctx, `x`, 2)
+	r.NoError(err)
+	r.Equal(3.5, v)
+	r.True(client.TTL(ctx, daily.Key()).Val() > 24*time.Hour)
+
+	yesterday := daily.At(time.Now().AddDate(0, 0, -1))
+	r.NotEqual(daily.Key(), yesterday.Key())
+	n, err := yesterday.Count(ctx)
+	r.NoError(err)
+	r.Zero(n)
+}
+
+func TestLeaderboardFractionalScore(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	//累加小数成绩不截断
+	sum := rdb.MustNewLeaderboard(&rdb.LeaderboardOption{Name: `test_fraction_sum`, Mode: rdb.LeaderboardModeSum}, client)
+	r.NoError(sum.Reset(ctx))
+
+	for _, score := range []float64{0.5, 0.25, 1.75} {
+		_, _, err := sum.Submit(ctx, `a`, score)
+		r.NoError(err)
+	}
+
+	e, err := sum.Get(ctx, `a`)
+	r.NoError(err)
+	r.Equal(2.5, e.Score)
+
+	//最好成绩比较不截断
+	best := rdb.MustNewLeaderboard(&rdb.LeaderboardOption{Name: `test_fraction_best`}, client)
+	r.NoError(best.Reset(ctx))
+
+	_, _, err = best.Submit(ctx, `a`, 2.5)
+	r.NoError(err)
+
+	v, ok, err := best.Submit(ctx, `a`, 2.2)
+	r.NoError(err)
+	r.False(ok)
+	r.Equal(2.5, v)
+}
@@ -0,0 +1,72 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	for _, algo := range []rdb.LimitAlgorithm{
+		rdb.LimitAlgorithmSlidingWindow,
+		rdb.LimitAlgorithmGCRA,
+		rdb.LimitAlgorithmTokenBucket,
+	} {
+		key := `test`
+		option := &rdb.LimiterOption{Algorithm: algo, Limit: 3, Period: 1 * time.Second}
+		limiter := rdb.MustNewLimiter(`test_`, option, client)
+		r.NoError(limiter.Reset(ctx, key))
+
+		//周期内最多请求3次
+		for i := 0; i < 3; i++ {
+			rs, err := limiter.Allow(ctx, key)
+			r.NoError(err)
+			r.True(rs.Allowed, algo)
+			r.EqualValues(2-i, rs.Remaining, algo)
+			r.True(rs.ResetAfter > 0)
+		}
+
+		rs, err := limiter.Allow(ctx, key)
+		r.NoError(err)
+		r.False(rs.Allowed, algo)
+		r.EqualValues(0, rs.Remaining)
+		r.True(rs.RetryAfter > 0 && rs.RetryAfter <= 1*time.Second, algo)
+
+		//请求数超过限制，永远无法通过
+		rs, err = limiter.AllowN(ctx, key, 4)
+		r.NoError(err)
+		r.False(rs.Allowed)
+		r.EqualValues(-1, rs.RetryAfter)
+
+		//等待配额恢复
+		time.Sleep(1500 * time.Millisecond)
+		rs, err = limiter.AllowN(ctx, key, 3)
+		r.NoError(err)
+		r.True(rs.Allowed, algo)
+		r.EqualValues(0, rs.Remaining)
+	}
+}
+
+func TestLimiterFailOpen(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: `localhost:1`})
+
+	//redis出错时默认拒绝
+	limiter := rdb.MustNewLimiter(`test_`, &rdb.LimiterOption{Limit: 1}, client)
+	rs, err := limiter.Allow(ctx, `test`)
+	r.Error(err)
+	r.False(rs.Allowed)
+
+	limiter = rdb.MustNewLimiter(`test_`, &rdb.LimiterOption{Limit: 1, FailOpen: true}, client)
+	rs, err = limiter.Allow(ctx, `test`)
+	r.Error(err)
+	r.True(rs.Allowed)
+}
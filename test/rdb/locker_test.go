@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/bingooh/b-go-util/rdb"
 	"github.com/bsm/redislock"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
 	"sync"
 	"testing"
@@ -102,3 +103,233 @@ func TestRedisSessionLock(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestReentrantLock(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	key := `test.lock.2`
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, `{`+key+`}`, `{`+key+`}:lease`).Err())
+
+	locker := rdb.NewLocker(client)
+
+	//同1持有者可重入
+	lock1, err := locker.ObtainReentrantLock(ctx, key, `o1`, 1*time.Second)
+	r.NoError(err)
+	lock2, err := locker.ObtainReentrantLock(ctx, key, `o1`, 1*time.Second)
+	r.NoError(err)
+
+	_, err = locker.ObtainReentrantLock(ctx, key, `o2`, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+
+	//自动续约，持有次数为0才释放锁
+	time.Sleep(2 * time.Second)
+	r.NoError(lock1.Release(ctx))
+	_, err = locker.ObtainReentrantLock(ctx, key, `o2`, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+
+	r.NoError(lock2.Release(ctx))
+	lock3, err := locker.ObtainReentrantLock(ctx, key, `o2`, 1*time.Second)
+	r.NoError(err)
+	r.NoError(lock3.Release(ctx))
+}
+
+func TestRWLock(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	key := `test.lock.3`
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, `{`+key+`}`, `{`+key+`}:lease`).Err())
+
+	locker := rdb.NewLocker(client)
+
+	//读锁可同时持有
+	rl1, err := locker.ObtainReadLock(ctx, key, ``, 1*time.Second)
+	r.NoError(err)
+	rl2, err := locker.ObtainReadLock(ctx, key, ``, 1*time.Second)
+	r.NoError(err)
+
+	_, err = locker.ObtainWriteLock(ctx, key, ``, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+
+	r.NoError(rl1.Release(ctx))
+	r.NoError(rl2.Release(ctx))
+
+	//写锁与读锁互斥
+	wl, err := locker.ObtainWriteLock(ctx, key, `w1`, 1*time.Second)
+	r.NoError(err)
+
+	_, err = locker.ObtainReadLock(ctx, key, ``, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+	r.NoError(wl.Release(ctx))
+
+	//模拟读锁持有者崩溃(未续约)，租约到期后可获取写锁
+	r.NoError(client.HSet(ctx, `{`+key+`}`, `mode`, `read`, `crashed`, 1).Err())
+	r.NoError(client.ZAdd(ctx, `{`+key+`}:lease`, &redis.Z{Score: float64(time.Now().UnixMilli() - 1), Member: `crashed`}).Err())
+	wl, err = locker.ObtainWriteLock(ctx, key, `w1`, 1*time.Second)
+	r.NoError(err)
+	r.NoError(wl.Release(ctx))
+}
+
+func TestFairLock(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	key := `test.lock.4`
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, `{`+key+`}`, `{`+key+`}:queue`, `{`+key+`}:timeout`).Err())
+
+	lock, err := rdb.NewLocker(client).ObtainFairLock(ctx, key, 1*time.Second)
+	r.NoError(err)
+
+	//多个协程按排队顺序获取锁
+	n := 5
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var orders []int
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+
+			locker := rdb.NewLocker(newRedisClient()).WithRetryOption(100, 100*time.Millisecond)
+			l, err := locker.ObtainFairLock(ctx, key, 1*time.Second)
+			r.NoError(err)
+
+			mu.Lock()
+			orders = append(orders, i)
+			mu.Unlock()
+
+			r.NoError(l.Release(ctx))
+		}()
+
+		time.Sleep(50 * time.Millisecond) //确保协程按顺序排队
+	}
+
+	r.NoError(lock.Release(ctx))
+	wg.Wait()
+	r.Equal([]int{0, 1, 2, 3, 4}, orders)
+}
+
+func TestFencingToken(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	key := `{test.fencing}:lock`
+	dataKey := `{test.fencing}:data`
+	tokenKey := `{test.fencing}:token`
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, key, rdb.FencingTokenKey(key), dataKey, tokenKey).Err())
+
+	locker := rdb.NewLocker(newRedisClient()).WithFencingToken(true)
+
+	//每次获取锁，防护令牌单调递增
+	lock1, err := locker.ObtainSessionLock(ctx, key, 1*time.Second)
+	r.NoError(err)
+	r.EqualValues(1, lock1.FencingToken())
+
+	//锁被占用时，无论是否启用防护令牌均无法获取锁
+	_, err = locker.ObtainSessionLock(ctx, key, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+	_, err = rdb.NewLocker(newRedisClient()).ObtainSessionLock(ctx, key, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+
+	//持续续约
+	time.Sleep(2 * time.Second)
+	r.False(lock1.IsReleased())
+
+	ok, err := rdb.SetFenced(ctx, client, tokenKey, dataKey, lock1.FencingToken(), 1)
+	r.NoError(err)
+	r.True(ok)
+
+	//模拟lock1过期，lock2获取锁并写入数据
+	r.NoError(client.Del(ctx, key).Err())
+	lock2, err := locker.ObtainSessionLock(ctx, key, 1*time.Second)
+	r.NoError(err)
+	r.EqualValues(2, lock2.FencingToken())
+
+	ok, err = rdb.SetFenced(ctx, client, tokenKey, dataKey, lock2.FencingToken(), 2)
+	r.NoError(err)
+	r.True(ok)
+
+	//lock1使用旧令牌写入将被拒绝
+	ok, err = rdb.SetFenced(ctx, client, tokenKey, dataKey, lock1.FencingToken(), 3)
+	r.NoError(err)
+	r.False(ok)
+	v, err := client.Get(ctx, dataKey).Int()
+	r.NoError(err)
+	r.EqualValues(2, v)
+
+	ok, err = rdb.HSetFenced(ctx, client, dataKey+`:h`, `f1`, lock2.FencingToken(), 1)
+	r.NoError(err)
+	r.True(ok)
+	ok, err = rdb.HSetFenced(ctx, client, dataKey+`:h`, `f1`, lock1.FencingToken(), 2)
+	r.NoError(err)
+	r.False(ok)
+	r.NoError(client.Del(ctx, dataKey+`:h`).Err())
+
+	r.NoError(lock2.Release(ctx))
+
+	//未启用防护令牌则为0
+	lock3, err := rdb.NewLocker(newRedisClient()).ObtainSessionLock(ctx, key, 1*time.Second)
+	r.NoError(err)
+	r.EqualValues(0, lock3.FencingToken())
+	r.NoError(lock3.Release(ctx))
+}
+
+func TestSessionLockInfo(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	key := `test.lock.6`
+	client := newRedisClient()
+	r.NoError(client.Del(ctx, key, `{`+key+`}`, `{`+key+`}:lease`).Err())
+
+	locker := rdb.NewLocker(client)
+
+	//普通锁
+	lock, err := locker.ObtainSessionLock(ctx, key, 10*time.Second)
+	r.NoError(err)
+	r.Equal(key, lock.Key())
+	r.Equal(client.Get(ctx, key).Val(), lock.Token())
+	r.Empty(lock.Metadata())
+	r.NotNil(lock.Lock)
+	r.Equal(lock.Token(), lock.Lock.Token())
+
+	r.NoError(lock.Refresh(ctx, 20*time.Second, nil))
+	ttl, err := lock.TTL(ctx)
+	r.NoError(err)
+	r.True(ttl > 10*time.Second)
+
+	r.NoError(lock.Release(ctx))
+	ttl, err = lock.TTL(ctx)
+	r.NoError(err)
+	r.Zero(ttl)
+
+	//读写锁
+	lock, err = locker.ObtainWriteLock(ctx, key, `w1`, 10*time.Second)
+	r.NoError(err)
+	r.Equal(`w1`, lock.Token())
+	r.Nil(lock.Lock)
+
+	r.NoError(lock.Refresh(ctx, 20*time.Second, nil))
+	ttl, err = lock.TTL(ctx)
+	r.NoError(err)
+	r.True(ttl > 10*time.Second)
+
+	r.NoError(lock.Release(ctx))
+	ttl, err = lock.TTL(ctx)
+	r.NoError(err)
+	r.Zero(ttl)
+
+	//信号量
+	lock, err = locker.Semaphore(key, 1).Acquire(ctx, 10*time.Second)
+	r.NoError(err)
+	ttl, err = lock.TTL(ctx)
+	r.NoError(err)
+	r.True(ttl > 5*time.Second)
+	r.NoError(lock.Release(ctx))
+}
@@ -1,10 +1,18 @@
 package rdb
 
 import (
+	"context"
 	"fmt"
 	"github.com/bingooh/b-go-util/rdb/scheduler"
 	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -22,7 +30,7 @@ func TestScheduler(t *testing.T) {
 		}
 	}
 
-	s1 := scheduler.MustNewSchedulerFromDefaultCfgFile()
+	s1 := scheduler.MustNewSchedulerFromDefaultCfgFile().WithClient(newRedisClient())
 	r.Panics(func() {
 		//任务名称必须与已有任务选项匹配，否则崩溃
 		s1.MustAddTaskFn(`txx`, newTask(`txx`, 0))
@@ -34,23 +42,654 @@ func TestScheduler(t *testing.T) {
 
 	//启动另1个定时器，执行任务t1
 	//2个任务t1会竞争分布式锁，所以仍然是大概每5秒执行1次
-	s2 := scheduler.MustNewSchedulerFromDefaultCfgFile()
+	s2 := scheduler.MustNewSchedulerFromDefaultCfgFile().WithClient(newRedisClient())
 	s2.MustAddTaskFn(`t1`, newTask(`t1`, 5*time.Second))
 	s2.Start()
 
 	time.Sleep(20 * time.Second)
-	s1.Stop()
-	s2.Stop()
+	s1.Stop(context.Background())
+	s2.Stop(context.Background())
 	fmt.Println(`done1`)
 
 	//t3指定下次执行时间，实际每3秒执行1次
-	s3 := scheduler.MustNewSchedulerFromDefaultCfgFile()
+	s3 := scheduler.MustNewSchedulerFromDefaultCfgFile().WithClient(newRedisClient())
 	s3.MustAddTaskFn(`t3`, func(ctx scheduler.Context) error {
 		return ctx.SetTaskNextInvokeTime(time.Now().Add(3 * time.Second))
 	})
 	s3.Start()
 	time.Sleep(10 * time.Second)
-	s3.Stop()
+	s3.Stop(context.Background())
 	fmt.Println(`done2`)
 
 }
+
+func TestSchedulerCron(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	option := &scheduler.Option{
+		TaskKeyPrefix:     `test_task:`,
+		EnableCronSeconds: true,
+		Tasks: map[string]*scheduler.TaskOption{
+			`cron`:    {Cron: `* * * * * *`, TimeZone: `Asia/Shanghai`},
+			`catchup`: {Cron: `* * * * * *`, Misfire: scheduler.MisfireCatchUp, MisfireCatchUp: 2},
+			`skip`:    {Cron: `* * * * * *`, Misfire: scheduler.MisfireSkip},
+		},
+	}
+
+	s := scheduler.MustNewScheduler(option).WithClient(client)
+	for name := range option.Tasks {
+		client.Del(ctx, option.MustGetTaskOption(name).TaskInvokeTimeKey())
+	}
+
+	//模拟停机10秒，错过多次执行
+	past := time.Now().Add(-10 * time.Second).Unix()
+	client.Set(ctx, option.MustGetTaskOption(`catchup`).TaskInvokeTimeKey(), past, 0)
+	client.Set(ctx, option.MustGetTaskOption(`skip`).TaskInvokeTimeKey(), past, 0)
+
+	var lock sync.Mutex
+	runs := make(map[string][]time.Time)
+	newTask := func(name string) scheduler.TaskFn {
+		return func(ctx scheduler.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			runs[name] = append(runs[name], ctx.ScheduledTime())
+			return nil
+		}
+	}
+
+	for name := range option.Tasks {
+		s.MustAddTaskFn(name, newTask(name))
+	}
+
+	s.Start()
+	time.Sleep(1500 * time.Millisecond)
+
+	lock.Lock()
+	r.Len(runs[`catchup`], 2) //仅补执行最近2次
+	r.Equal(runs[`catchup`][0].Add(time.Second), runs[`catchup`][1])
+	for _, t := range runs[`skip`] { //跳过错过的执行，仅执行未超过InvokeInternal+1s的执行时间
+		r.True(t.Unix() > past+5)
+	}
+	lock.Unlock()
+
+	time.Sleep(2 * time.Second)
+	s.Stop(context.Background())
+
+	lock.Lock()
+	defer lock.Unlock()
+	r.NotEmpty(runs[`cron`]) //首次调度仅保存下次执行时间，之后每秒执行
+	r.NotEmpty(runs[`skip`])
+	r.Equal(`Asia/Shanghai`, runs[`cron`][0].Location().String())
+}
+
+func TestSchedulerMisfire(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	option := &scheduler.Option{
+		TaskKeyPrefix:     `test_misfire:`,
+		EnableCronSeconds: true,
+		Tasks: map[string]*scheduler.TaskOption{
+			`skip`:    {Cron: `0 0 0 * * *`, Misfire: scheduler.MisfireSkip},
+			`once`:    {Cron: `0 0 0 * * *`},
+			`catchup`: {Cron: `* * * * * *`, Misfire: scheduler.MisfireCatchUp, MisfireCatchUp: 2},
+			`reload`:  {Cron: `0 0 0 1 1 *`},
+		},
+	}
+
+	s := scheduler.MustNewScheduler(option).WithClient(client)
+
+	//每日任务仅错过1次执行，但已错过数小时
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if now.Sub(midnight) < time.Minute {
+		t.Skip(`临近0点，等待1分钟后测试`)
+	}
+
+	for name, o := range option.Tasks {
+		client.Del(ctx, o.TaskInvokeTimeKey())
+		if name != `reload` {
+			client.Set(ctx, o.TaskInvokeTimeKey(), midnight.Unix(), 0)
+		}
+	}
+
+	//每秒任务停机2小时，错过执行次数超过上限
+	client.Set(ctx, option.MustGetTaskOption(`catchup`).TaskInvokeTimeKey(), now.Add(-2*time.Hour).Unix(), 0)
+
+	var lock sync.Mutex
+	runs := make(map[string][]time.Time)
+	for name := range option.Tasks {
+		name := name
+		s.MustAddTaskFn(name, func(ctx scheduler.Context) error {
+			lock.Lock()
+			defer lock.Unlock()
+
+			runs[name] = append(runs[name], ctx.ScheduledTime())
+			return nil
+		})
+	}
+
+	s.Start()
+	defer s.Stop(ctx)
+	time.Sleep(1500 * time.Millisecond)
+
+	lock.Lock()
+	r.Empty(runs[`skip`])
+	r.Len(runs[`once`], 1)
+	r.True(runs[`once`][0].Equal(midnight))
+
+	//补执行最近2次，而不是最早的2次
+	r.True(len(runs[`catchup`]) >= 2)
+	r.WithinDuration(now, runs[`catchup`][1], 2*time.Second)
+	r.Equal(runs[`catchup`][0].Add(time.Second), runs[`catchup`][1])
+	r.Empty(runs[`reload`])
+	lock.Unlock()
+
+	//变更Cron后按新配置重新计算下次执行时间
+	r.NoError(s.Reload(&scheduler.Option{Tasks: map[string]*scheduler.TaskOption{
+		`skip`:    {Cron: `0 0 0 * * *`, Misfire: scheduler.MisfireSkip},
+		`once`:    {Cron: `0 0 0 * * *`},
+		`catchup`: {Cron: `* * * * * *`, Misfire: scheduler.MisfireCatchUp, MisfireCatchUp: 2},
+		`reload`:  {Cron: `* * * * * *`},
+	}}))
+	time.Sleep(3 * time.Second)
+
+	lock.Lock()
+	defer lock.Unlock()
+	r.NotEmpty(runs[`reload`])
+}
+
+func TestSchedulerInvokeTime(t *testing.T) {
+	r := require.New(t)
+
+	option := (&scheduler.Option{
+		Redis: &redis.Options{},
+		Tasks: map[string]*scheduler.TaskOption{
+			`t`: {
+				InvokeTimeRanges: [][]int{{900, 1130}, {1400, 1800}},
+				Weekdays:         []int{1, 2, 3, 4, 5},
+				TimeZone:         `UTC`,
+			},
+		},
+	}).MustNormalize()
+
+	o := option.MustGetTaskOption(`t`)
+	r.False(o.IsCron())
+	r.True(o.IsInInvokeTime(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))  //星期一
+	r.False(o.IsInInvokeTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))) //不在时间区间
+	r.True(o.IsInInvokeTime(time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)))
+	r.False(o.IsInInvokeTime(time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC))) //星期六
+
+	r.Panics(func() {
+		(&scheduler.Option{Tasks: map[string]*scheduler.TaskOption{`t`: {Cron: `bad cron`}}}).MustNormalize()
+	})
+
+	r.Panics(func() {
+		(&scheduler.Option{Tasks: map[string]*scheduler.TaskOption{`t`: {Misfire: scheduler.MisfireSkip}}}).MustNormalize()
+	})
+}
+
+func TestSchedulerTaskStatus(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	option := &scheduler.Option{
+		TaskKeyPrefix: `test_status:`,
+		Node:          `node1`,
+		HistorySize:   3,
+		Tasks: map[string]*scheduler.TaskOption{
+			`ok`:   {InvokeInternal: 100 * time.Millisecond},
+			`fail`: {InvokeInternal: 100 * time.Millisecond},
+		},
+	}
+
+	s := scheduler.MustNewScheduler(option).WithClient(client)
+	for name := range option.Tasks {
+		o := option.MustGetTaskOption(name)
+		client.Del(ctx, o.TaskInvokeTimeKey(), o.TaskHistoryKey(), o.TaskStatusKey())
+	}
+
+	s.MustAddTaskFn(`ok`, func(ctx scheduler.Context) error { return nil })
+	s.MustAddTaskFn(`fail`, func(ctx scheduler.Context) error { return fmt.Errorf(`boom`) })
+	s.Start()
+	time.Sleep(700 * time.Millisecond)
+	s.Stop(context.Background())
+
+	list, err := s.ListTaskStatus(ctx)
+	r.NoError(err)
+	r.Len(list, 2)
+	r.Equal(`fail`, list[0].Name)
+
+	fail := list[0]
+	r.True(fail.FailureCount >= 3)
+	r.Zero(fail.SuccessCount)
+	r.Nil(fail.LastSuccess)
+	r.Equal(`boom`, fail.LastFailure.Error)
+	r.Equal(`node1`, fail.LastFailure.Node)
+
+	ok := list[1]
+	r.True(ok.SuccessCount >= 3)
+	r.NotNil(ok.LastSuccess)
+	r.Nil(ok.LastFailure)
+	r.Equal(1, ok.LastSuccess.CatchUpIndex)
+
+	//执行记录数量受HistorySize限制
+	runs, err := s.TaskHistory(ctx, `ok`, 0, 10)
+	r.NoError(err)
+	r.Len(runs, 3)
+	r.True(runs[0].StartTime.After(runs[1].StartTime))
+	r.True(runs[0].Success())
+	r.False(runs[0].EndTime.Before(runs[0].StartTime))
+}
+
+func TestSchedulerControl(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	option := &scheduler.Option{
+		TaskKeyPrefix: `test_control:`,
+		Tasks: map[string]*scheduler.TaskOption{
+			`yearly`:   {Cron: `@yearly`, InvokeInternal: 100 * time.Millisecond},
+			`interval`: {InvokeInternal: 100 * time.Millisecond},
+		},
+	}
+
+	s := scheduler.MustNewScheduler(option).WithClient(client)
+	for name := range option.Tasks {
+		o := option.MustGetTaskOption(name)
+		client.Del(ctx, o.TaskInvokeTimeKey(), o.TaskPauseKey(), o.TaskTriggerKey(), o.TaskHistoryKey(), o.TaskStatusKey())
+	}
+
+	counter := util.NewAtomicInt64(0)
+	yearly := util.NewAtomicInt64(0)
+	s.MustAddTaskFn(`interval`, func(ctx scheduler.Context) error {
+		counter.Incr(1)
+		return nil
+	})
+	s.MustAddTaskFn(`yearly`, func(ctx scheduler.Context) error {
+		yearly.Incr(1)
+		return nil
+	})
+
+	router := gin.New()
+	s.RegisterHandlers(router.Group(`/admin`))
+	call := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	s.Start()
+	defer s.Stop(context.Background())
+	time.Sleep(300 * time.Millisecond)
+	r.True(counter.Value() > 0)
+	r.Zero(yearly.Value())
+
+	//手动触发，通过pub/sub立即执行，不修改下次执行时间
+	r.Equal(http.StatusNoContent, call(http.MethodPost, `/admin/tasks/yearly/trigger`).Code)
+	time.Sleep(100 * time.Millisecond)
+	r.EqualValues(1, yearly.Value())
+
+	status, err := s.TaskStatus(ctx, `yearly`)
+	r.NoError(err)
+	r.EqualValues(1, status.SuccessCount)
+	r.True(status.NextInvokeTime.After(time.Now().AddDate(0, 0, 1)))
+
+	//暂停后不再定时执行
+	r.Equal(http.StatusNoContent, call(http.MethodPost, `/admin/tasks/interval/pause`).Code)
+	time.Sleep(100 * time.Millisecond)
+	n := counter.Value()
+	time.Sleep(300 * time.Millisecond)
+	r.Equal(n, counter.Value())
+
+	w := call(http.MethodGet, `/admin/tasks/interval`)
+	r.Equal(http.StatusOK, w.Code)
+	r.Contains(w.Body.String(), `"paused":true`)
+
+	r.NoError(s.Resume(ctx, `interval`))
+	time.Sleep(300 * time.Millisecond)
+	r.True(counter.Value() > n)
+
+	//设置下次执行时间
+	next := time.Now().Add(time.Hour).Truncate(time.Second)
+	r.Equal(http.StatusNoContent, call(http.MethodPut, `/admin/tasks/yearly/next-invoke-time?time=`+next.Format(time.RFC3339)).Code)
+	status, err = s.TaskStatus(ctx, `yearly`)
+	r.NoError(err)
+	r.True(next.Equal(status.NextInvokeTime))
+
+	r.Equal(http.StatusNotFound, call(http.MethodPost, `/admin/tasks/none/trigger`).Code)
+	r.Equal(http.StatusBadRequest, call(http.MethodGet, `/admin/tasks/yearly/history?count=x`).Code)
+
+	w = call(http.MethodGet, `/admin/tasks/yearly/history`)
+	r.Equal(http.StatusOK, w.Code)
+	r.Contains(w.Body.String(), `"catch_up_index":1`)
+}
+
+func TestSchedulerShards(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	newOption := func() *scheduler.Option {
+		return &scheduler.Option{
+			TaskKeyPrefix: `test_shard:`,
+			Tasks: map[string]*scheduler.TaskOption{
+				`sharded`: {Shards: 4, InvokeInternal: 100 * time.Millisecond},
+			},
+		}
+	}
+
+	r.Panics(func() {
+		(&scheduler.Option{Tasks: map[string]*scheduler.TaskOption{`t`: {Shards: 2, DisableTaskLock: true}}}).MustNormalize()
+	})
+
+	o := newOption().MustNormalize().MustGetTaskOption(`sharded`)
+	client.Del(ctx, o.TaskInvokeTimeKey(), o.TaskTriggerKey(), o.TaskShardRunKey(), o.TaskShardDoneKey(), o.TaskHistoryKey(), o.TaskStatusKey())
+	r.NoError(client.Set(ctx, o.TaskInvokeTimeKey(), time.Now().Add(time.Hour).Unix(), 0).Err())
+
+	var mu sync.Mutex
+	counts := make(map[int]int)
+	failed := util.NewAtomicBool(false)
+	task := func(ctx scheduler.Context) error {
+		r.Equal(4, ctx.ShardCount())
+
+		mu.Lock()
+		counts[ctx.ShardIndex()]++
+		mu.Unlock()
+
+		//分片2首次执行失败，下次检查时重试
+		if ctx.ShardIndex() == 2 && failed.CASwap(false) {
+			return fmt.Errorf(`shard failed`)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+
+	//2个调度器节点共同执行各分片
+	s1 := scheduler.MustNewScheduler(newOption()).WithClient(client)
+	s1.MustAddTaskFn(`sharded`, task)
+	s1.Start()
+	defer s1.Stop(context.Background())
+
+	s2 := scheduler.MustNewScheduler(newOption()).WithClient(client)
+	s2.MustAddTaskFn(`sharded`, task)
+	s2.Start()
+	defer s2.Stop(context.Background())
+
+	time.Sleep(300 * time.Millisecond)
+	r.Empty(counts)
+
+	r.NoError(s1.TriggerNow(ctx, `sharded`))
+	time.Sleep(time.Second)
+
+	mu.Lock()
+	r.Equal(map[int]int{0: 1, 1: 1, 2: 2, 3: 1}, counts)
+	mu.Unlock()
+
+	//全部分片完成后删除分片执行状态
+	n, err := client.Exists(ctx, o.TaskShardRunKey(), o.TaskShardDoneKey()).Result()
+	r.NoError(err)
+	r.Zero(n)
+
+	runs, err := s1.TaskHistory(ctx, `sharded`, 0, 10)
+	r.NoError(err)
+	r.Len(runs, 5)
+	r.Equal(4, runs[0].ShardCount)
+}
+
+func TestSchedulerShardsInterval(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	option := &scheduler.Option{
+		TaskKeyPrefix: `test_shard_interval:`,
+		Tasks: map[string]*scheduler.TaskOption{
+			`sharded`: {Shards: 2, InvokeInternal: 500 * time.Millisecond},
+		},
+	}
+
+	s := scheduler.MustNewScheduler(option).WithClient(client)
+	o := option.MustGetTaskOption(`sharded`)
+	client.Del(ctx, o.TaskInvokeTimeKey(), o.TaskTriggerKey(), o.TaskShardRunKey(), o.TaskShardDoneKey(), o.TaskHistoryKey(), o.TaskStatusKey())
+
+	//分片1首次执行失败，首次分片执行跨越2次检查
+	var mu sync.Mutex
+	var starts, ends []time.Time
+	failed := util.NewAtomicBool(false)
+	s.MustAddTaskFn(`sharded`, func(ctx scheduler.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ctx.ShardIndex() == 1 && failed.CASwap(false) {
+			return fmt.Errorf(`shard failed`)
+		}
+
+		starts = append(starts, time.Now())
+		time.Sleep(50 * time.Millisecond)
+		ends = append(ends, time.Now())
+		return nil
+	})
+	s.Start()
+	defer s.Stop(ctx)
+
+	time.Sleep(4 * time.Second)
+
+	//全部分片完成后至少间隔InvokeInternal才开始下次分片执行
+	mu.Lock()
+	defer mu.Unlock()
+	r.True(len(starts) >= 4)
+	for i := 2; i < len(starts); i += 2 {
+		r.True(starts[i].Sub(ends[i-1]) >= o.InvokeInternal, `run %v started %v after last run`, i/2, starts[i].Sub(ends[i-1]))
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+
+	var o *scheduler.TaskOption
+	newScheduler := func(to *scheduler.TaskOption, task scheduler.TaskFn) *scheduler.Scheduler {
+		option := &scheduler.Option{
+			TaskKeyPrefix: `test_stop:`,
+			Tasks:         map[string]*scheduler.TaskOption{`t`: to},
+		}
+
+		s := scheduler.MustNewScheduler(option).WithClient(client)
+		o = option.MustGetTaskOption(`t`)
+		client.Del(ctx, o.TaskInvokeTimeKey(), o.TaskLockKey(), o.TaskHistoryKey(), o.TaskStatusKey())
+		s.MustAddTaskFn(`t`, task)
+		return s
+	}
+
+	//等待执行中的任务结束，然后释放任务锁
+	started, finished := util.NewAtomicBool(false), util.NewAtomicBool(false)
+	s := newScheduler(&scheduler.TaskOption{InvokeInternal: 100 * time.Millisecond}, func(ctx scheduler.Context) error {
+		started.Set(true)
+		time.Sleep(500 * time.Millisecond)
+		finished.Set(true)
+		return ctx.SetTaskNextInvokeTime(time.Now().Add(time.Hour))
+	})
+	s.Start()
+	time.Sleep(200 * time.Millisecond)
+	r.True(started.Value())
+
+	stopCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	r.NoError(s.Stop(stopCtx))
+	r.True(finished.Value())
+
+	n, err := client.Exists(ctx, o.TaskLockKey()).Result()
+	r.NoError(err)
+	r.Zero(n)
+
+	//等待超时后取消任务ctx
+	canceled := util.NewAtomicBool(false)
+	s = newScheduler(&scheduler.TaskOption{InvokeInternal: 100 * time.Millisecond}, func(ctx scheduler.Context) error {
+		<-ctx.Context().Done()
+		canceled.Set(true)
+		return ctx.Context().Err()
+	})
+	s.Start()
+	time.Sleep(200 * time.Millisecond)
+
+	stopCtx, cancel = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	r.ErrorIs(s.Stop(stopCtx), context.DeadlineExceeded)
+	r.True(canceled.Value())
+
+	n, err = client.Exists(ctx, o.TaskLockKey()).Result()
+	r.NoError(err)
+	r.Zero(n)
+
+	//每次执行超时
+	s = newScheduler(&scheduler.TaskOption{InvokeInternal: 100 * time.Millisecond, Timeout: 100 * time.Millisecond}, func(ctx scheduler.Context) error {
+		<-ctx.Context().Done()
+		return ctx.Context().Err()
+	})
+	s.Start()
+	time.Sleep(500 * time.Millisecond)
+	r.NoError(s.Stop(ctx))
+
+	status, err := s.TaskStatus(ctx, `t`)
+	r.NoError(err)
+	r.True(status.FailureCount > 0)
+	r.Equal(context.DeadlineExceeded.Error(), status.LastFailure.Error)
+}
+
+func TestSchedulerReload(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+	for _, name := range []string{`a`, `b`} {
+		client.Del(ctx, `test_reload::invoke:`+name, `test_reload::lock:`+name)
+	}
+
+	s := scheduler.MustNewScheduler(&scheduler.Option{
+		TaskKeyPrefix: `test_reload:`,
+		Tasks:         map[string]*scheduler.TaskOption{`a`: {InvokeInternal: 100 * time.Millisecond}},
+	}).WithClient(client)
+
+	a, b := util.NewAtomicInt64(0), util.NewAtomicInt64(0)
+	intervals := make(chan time.Duration, 100)
+	s.MustAddTaskFn(`a`, func(ctx scheduler.Context) error {
+		a.Incr(1)
+		o := ctx.Option()
+		time.Sleep(200 * time.Millisecond)
+		intervals <- o.InvokeInternal
+		return nil
+	})
+	s.Start()
+	defer s.Stop(ctx)
+
+	//运行时添加和删除任务
+	s.MustAddTaskWithOption(`b`, &scheduler.TaskOption{InvokeInternal: 100 * time.Millisecond}, scheduler.TaskFn(func(ctx scheduler.Context) error {
+		b.Incr(1)
+		return nil
+	}))
+	time.Sleep(300 * time.Millisecond)
+	r.True(a.Value() > 0)
+	r.True(b.Value() > 0)
+
+	s.RemoveTask(`b`)
+	time.Sleep(100 * time.Millisecond)
+	n := b.Value()
+	time.Sleep(300 * time.Millisecond)
+	r.Equal(n, b.Value())
+
+	//配置文件禁用任务a，删除任务b配置
+	dir := t.TempDir()
+	file := filepath.Join(dir, `scheduler`)
+	writeCfg := func(cfg string) {
+		r.NoError(os.WriteFile(file+`.toml`, []byte(cfg), 0644))
+	}
+
+	writeCfg("[tasks.a]\nDisabled = true\nInvokeInternal = \"100ms\"\n")
+	r.NoError(s.ReloadCfgFile(file))
+	r.Panics(func() {
+		s.TriggerNow(ctx, `b`)
+	})
+
+	time.Sleep(300 * time.Millisecond)
+	n = a.Value()
+	time.Sleep(300 * time.Millisecond)
+	r.Equal(n, a.Value())
+
+	//执行中的任务仍使用原配置
+	for len(intervals) > 0 {
+		r.Equal(100*time.Millisecond, <-intervals)
+	}
+
+	//无效配置不应用任何变更
+	writeCfg("[tasks.a]\nCron = \"bad cron\"\n")
+	r.Error(s.ReloadCfgFile(file))
+
+	//重新启用任务a
+	writeCfg("[tasks.a]\nInvokeInternal = \"200ms\"\n")
+	s.WatchCfgFile(file, 100*time.Millisecond)
+	time.Sleep(800 * time.Millisecond)
+	r.True(a.Value() > n)
+	r.Equal(200*time.Millisecond, <-intervals)
+}
+
+func TestSchedulerReloadCodeTask(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+	for _, name := range []string{`a`, `b`} {
+		client.Del(ctx, `test_reload_code::invoke:`+name, `test_reload_code::lock:`+name)
+	}
+
+	s := scheduler.MustNewScheduler(&scheduler.Option{
+		TaskKeyPrefix: `test_reload_code:`,
+		Tasks:         map[string]*scheduler.TaskOption{`a`: {InvokeInternal: 100 * time.Millisecond}},
+	}).WithClient(client)
+
+	s.MustAddTaskFn(`a`, func(ctx scheduler.Context) error {
+		return nil
+	})
+	s.Start()
+	defer s.Stop(ctx)
+
+	b := util.NewAtomicInt64(0)
+	s.MustAddTaskWithOption(`b`, &scheduler.TaskOption{InvokeInternal: 100 * time.Millisecond}, scheduler.TaskFn(func(ctx scheduler.Context) error {
+		b.Incr(1)
+		return nil
+	}))
+
+	//配置文件不包含任务b，重新加载后仍保留任务b配置
+	file := filepath.Join(t.TempDir(), `scheduler`)
+	r.NoError(os.WriteFile(file+`.toml`, []byte("[tasks.a]\nInvokeInternal = \"200ms\"\n"), 0644))
+	r.NoError(s.ReloadCfgFile(file))
+
+	_, err := s.TaskStatus(ctx, `b`)
+	r.NoError(err)
+
+	n := b.Value()
+	time.Sleep(300 * time.Millisecond)
+	r.True(b.Value() > n)
+
+	//删除任务后按配置文件重新加载
+	s.RemoveTask(`b`)
+	r.NoError(s.ReloadCfgFile(file))
+	r.Panics(func() {
+		s.TaskStatus(ctx, `b`)
+	})
+}
@@ -0,0 +1,86 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memSegmentStore struct {
+	mu   sync.Mutex
+	data map[string]int64
+}
+
+func (s *memSegmentStore) Load(ctx context.Context, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[name], nil
+}
+
+func (s *memSegmentStore) Save(ctx context.Context, name string, maxID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxID > s.data[name] {
+		s.data[name] = maxID
+	}
+	return nil
+}
+
+func TestSegmentIDAllocator(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	store := &memSegmentStore{data: map[string]int64{}}
+	option := &rdb.SegmentIDAllocatorOption{Name: `test`, Step: 10}
+	a := rdb.MustNewSegmentIDAllocator(option, client).WithStore(store)
+	r.NoError(client.Del(ctx, a.Key()).Err())
+
+	//ID连续递增，剩余不足2个时后台预取下1号段
+	for i := 1; i <= 9; i++ {
+		id, err := a.Next(ctx)
+		r.NoError(err)
+		r.EqualValues(i, id)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	v, err := client.Get(ctx, a.Key()).Int64()
+	r.NoError(err)
+	r.EqualValues(20, v)
+	r.EqualValues(20, store.data[`test`])
+
+	//多个分配器分配的ID不重复
+	b := rdb.MustNewSegmentIDAllocator(&rdb.SegmentIDAllocatorOption{Name: `test`, Step: 10}, client)
+	ids := make(map[int64]bool)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, alloc := range []*rdb.SegmentIDAllocator{a, b} {
+		alloc := alloc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id, err := alloc.Next(ctx)
+				r.NoError(err)
+
+				mu.Lock()
+				r.False(ids[id])
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	r.Len(ids, 200)
+
+	//模拟redis数据丢失，从持久化存储的号段最大值继续分配
+	max := store.data[`test`]
+	r.NoError(client.Del(ctx, a.Key()).Err())
+	c := rdb.MustNewSegmentIDAllocator(&rdb.SegmentIDAllocatorOption{Name: `test`, Step: 10}, client).WithStore(store)
+	id, err := c.Next(ctx)
+	r.NoError(err)
+	r.EqualValues(max+1, id)
+}
@@ -0,0 +1,81 @@
+package rdb
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bsm/redislock"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	key := `test.semaphore`
+	r.NoError(client.Del(ctx, key).Err())
+
+	sem := rdb.NewLocker(client).Semaphore(key, 2)
+
+	//最多2个持有者，租约自动续约
+	l1, err := sem.Acquire(ctx, 1*time.Second)
+	r.NoError(err)
+	l2, err := sem.Acquire(ctx, 1*time.Second)
+	r.NoError(err)
+	_, err = sem.Acquire(ctx, 1*time.Second)
+	r.True(err == redislock.ErrNotObtained)
+
+	time.Sleep(2 * time.Second)
+	n, err := sem.Available(ctx)
+	r.NoError(err)
+	r.EqualValues(0, n)
+
+	r.NoError(l1.Release(ctx))
+	n, err = sem.Available(ctx)
+	r.NoError(err)
+	r.EqualValues(1, n)
+	r.NoError(l2.Release(ctx))
+
+	//模拟持有者崩溃，租约过期后许可被回收
+	r.NoError(client.ZAdd(ctx, key,
+		&redis.Z{Score: float64(time.Now().Add(-1 * time.Second).UnixMilli()), Member: `crashed1`},
+		&redis.Z{Score: float64(time.Now().Add(-1 * time.Second).UnixMilli()), Member: `crashed2`},
+	).Err())
+	l3, err := sem.Acquire(ctx, 1*time.Second)
+	r.NoError(err)
+	r.NoError(l3.Release(ctx))
+
+	//多个协程竞争许可，同时持有许可的数量不超过2
+	sem = rdb.NewLocker(client).WithRetryOption(100, 50*time.Millisecond).Semaphore(key, 2)
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			lock, err := sem.Acquire(ctx, 1*time.Second)
+			r.NoError(err)
+
+			v := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if v <= m || atomic.CompareAndSwapInt32(&maxRunning, m, v) {
+					break
+				}
+			}
+
+			time.Sleep(200 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			r.NoError(lock.Release(ctx))
+		}()
+	}
+	wg.Wait()
+	r.EqualValues(2, maxRunning)
+}
@@ -0,0 +1,74 @@
+package rdb
+
+import (
+	"context"
+	"errors"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamConsumer(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := newRedisClient()
+
+	stream := `test.stream`
+	r.NoError(client.Del(ctx, stream, stream+`:dead`).Err())
+
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	consumer := rdb.MustNewStreamConsumer(&rdb.StreamConsumerOption{
+		Stream:        stream,
+		Group:         `g1`,
+		Concurrency:   2,
+		Block:         100 * time.Millisecond,
+		ClaimInterval: 200 * time.Millisecond,
+		ClaimMinIdle:  100 * time.Millisecond,
+		MaxDeliveries: 3,
+		MaxLen:        100,
+	}, client, func(ctx context.Context, msg redis.XMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		v := msg.Values[`v`].(string)
+		handled[v]++
+
+		//消息b始终处理失败，消息c首次处理失败
+		if v == `b` || v == `c` && handled[v] == 1 {
+			return errors.New(`fail`)
+		}
+		return nil
+	})
+
+	consumer.Start()
+	time.Sleep(200 * time.Millisecond) //等待创建消费组
+
+	for _, v := range []string{`a`, `b`, `c`} {
+		_, err := rdb.PublishStream(ctx, client, stream, 100, map[string]interface{}{`v`: v})
+		r.NoError(err)
+	}
+
+	time.Sleep(2 * time.Second)
+	consumer.Stop()
+	r.False(consumer.IsRunning())
+
+	mu.Lock()
+	r.Equal(map[string]int{`a`: 1, `b`: 3, `c`: 2}, handled)
+	mu.Unlock()
+
+	//全部消息已确认
+	pending, err := client.XPending(ctx, stream, `g1`).Result()
+	r.NoError(err)
+	r.EqualValues(0, pending.Count)
+
+	//消息b转移到死信消息流
+	dead, err := client.XRange(ctx, stream+`:dead`, `-`, `+`).Result()
+	r.NoError(err)
+	r.Len(dead, 1)
+	r.Equal(`b`, dead[0].Values[`v`])
+	r.Equal(stream, dead[0].Values[`_stream`])
+}
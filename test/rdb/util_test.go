@@ -2,16 +2,36 @@ package rdb
 
 import (
 	"context"
+	"github.com/bingooh/b-go-util/internal/rdbtest"
 	"github.com/bingooh/b-go-util/rdb"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
+	"os"
 	"testing"
 	"time"
 )
 
+// 设置环境变量REDIS_ADDR则使用此redis，否则使用内存redis
+var memRedis = os.Getenv(`REDIS_ADDR`) == ``
+
+var memServer *rdbtest.Server
+
+var redisAddr = func() string {
+	if !memRedis {
+		return os.Getenv(`REDIS_ADDR`)
+	}
+
+	memServer = rdbtest.MustNewServer()
+	return memServer.Addr()
+}()
+
 func newRedisClient() *redis.Client {
+	if memRedis {
+		return memServer.NewClient(3)
+	}
+
 	o := &redis.Options{
-		Addr: "localhost:6389",
+		Addr: redisAddr,
 		DB:   3,
 	}
 
@@ -145,24 +165,33 @@ func TestUtilCA(t *testing.T) {
 	assertEqual(1)
 
 	//测试IncrNEQ，current==1
-	ok, val, err = rdb.IncrNEQ(ctx, client, key, 1, 1)
+	rs, err := rdb.IncrNEQ(ctx, client, key, 1, 1)
 	r.NoError(err)
-	r.False(ok)
-	r.EqualValues(1, val)
+	r.Equal(rdb.IncrResult{Applied: false, Old: 1, New: 1}, *rs)
 	assertEqual(1)
 
-	ok, val, err = rdb.IncrNEQ(ctx, client, key, 2, 1)
+	rs, err = rdb.IncrNEQ(ctx, client, key, 2, 1)
 	r.NoError(err)
-	r.True(ok)
-	r.EqualValues(2, val)
+	r.Equal(rdb.IncrResult{Applied: true, Old: 1, New: 2}, *rs)
 	assertEqual(2)
 
-	ok, val, err = rdb.IncrNEQ(ctx, client, key, 3, -1)
+	rs, err = rdb.IncrNEQ(ctx, client, key, 3, -1)
 	r.NoError(err)
-	r.True(ok)
-	r.EqualValues(1, val)
+	r.Equal(rdb.IncrResult{Applied: true, Old: 2, New: 1}, *rs)
 	assertEqual(1)
 
+	//val==0时仍可正确判断是否已新增
+	rs, err = rdb.IncrNEQ(ctx, client, key, 3, 0)
+	r.NoError(err)
+	r.Equal(rdb.IncrResult{Applied: true, Old: 1, New: 1}, *rs)
+
+	//key不存在，原值为0
+	r.NoError(client.Del(ctx, key).Err())
+	rs, err = rdb.IncrNEQ(ctx, client, key, 1, 2)
+	r.NoError(err)
+	r.Equal(rdb.IncrResult{Applied: true, Old: 0, New: 2}, *rs)
+	assertEqual(2)
+
 }
 
 func TestUtilCAH(t *testing.T) {
@@ -234,3 +263,117 @@ func TestUtilCAH(t *testing.T) {
 	r.True(ok)
 	assertExists(false)
 }
+
+func TestUtilCATTL(t *testing.T) {
+	r := require.New(t)
+
+	client := newRedisClient()
+	ctx := context.Background()
+	key1, key2 := `{test}:1`, `{test}:2`
+	r.NoError(client.Del(ctx, key1, key2).Err())
+
+	ttlOf := func(key string) time.Duration {
+		v, err := client.PTTL(ctx, key).Result()
+		r.NoError(err)
+		return v
+	}
+
+	//设置TTL
+	ok, err := rdb.SetEQWithTTL(ctx, client, key1, ``, 1, 10*time.Second)
+	r.NoError(err)
+	r.True(ok)
+	r.True(ttlOf(key1) > 9*time.Second)
+
+	//保留原TTL
+	ok, err = rdb.SetEQWithTTL(ctx, client, key1, 1, 2, redis.KeepTTL)
+	r.NoError(err)
+	r.True(ok)
+	r.True(ttlOf(key1) > 9*time.Second)
+	r.Equal(`2`, client.Get(ctx, key1).Val())
+
+	ok, err = rdb.SetNEQWithTTL(ctx, client, key1, 2, 3, redis.KeepTTL)
+	r.NoError(err)
+	r.False(ok)
+
+	//批量设置，任1个key的值不相等则全部不设置
+	ok, err = rdb.MSetEQ(ctx, client, redis.KeepTTL,
+		&rdb.CASItem{Key: key1, Expect: 1, Val: 3}, &rdb.CASItem{Key: key2, Expect: ``, Val: 3})
+	r.NoError(err)
+	r.False(ok)
+	r.Equal(int64(0), client.Exists(ctx, key2).Val())
+
+	ok, err = rdb.MSetEQ(ctx, client, redis.KeepTTL,
+		&rdb.CASItem{Key: key1, Expect: 2, Val: 3}, &rdb.CASItem{Key: key2, Expect: ``, Val: 3})
+	r.NoError(err)
+	r.True(ok)
+	r.Equal(`3`, client.Get(ctx, key1).Val())
+	r.Equal(`3`, client.Get(ctx, key2).Val())
+	r.True(ttlOf(key1) > 9*time.Second)
+
+	//hash批量设置
+	hkey := `{test}:h`
+	r.NoError(client.Del(ctx, hkey).Err())
+	ok, err = rdb.HMSetEQ(ctx, client, hkey, 10*time.Second,
+		&rdb.HCASItem{Field: `f1`, Expect: ``, Val: 1}, &rdb.HCASItem{Field: `f2`, Expect: ``, Val: 2})
+	r.NoError(err)
+	r.True(ok)
+	r.True(ttlOf(hkey) > 9*time.Second)
+
+	ok, err = rdb.HMSetEQ(ctx, client, hkey, 0,
+		&rdb.HCASItem{Field: `f1`, Expect: 1, Val: 3}, &rdb.HCASItem{Field: `f2`, Expect: 1, Val: 3})
+	r.NoError(err)
+	r.False(ok)
+	r.Equal(`1`, client.HGet(ctx, hkey, `f1`).Val())
+
+	//JSON字段
+	jkey := `{test}:json`
+	r.NoError(client.Set(ctx, jkey, `{"user":{"name":"a","age":1}}`, 10*time.Second).Err())
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `user.name`, `b`, `c`)
+	r.NoError(err)
+	r.False(ok)
+
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `user.name`, `a`, `c`)
+	r.NoError(err)
+	r.True(ok)
+
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `user.age`, 1, 2)
+	r.NoError(err)
+	r.True(ok)
+
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `user.sex`, nil, 1)
+	r.NoError(err)
+	r.True(ok)
+	r.True(ttlOf(jkey) > 9*time.Second)
+
+	v, err := client.Get(ctx, jkey).Result()
+	r.NoError(err)
+	r.JSONEq(`{"user":{"name":"c","age":2,"sex":1}}`, v)
+
+	//其他字段保持原样，不丢失大整数精度，空数组不变为空对象
+	raw := `{"id":1234567890123456789,"tags":[],"note":"a.b\\\"}","user":{"name":"a","ids":[9007199254740993]}}`
+	r.NoError(client.Set(ctx, jkey, raw, 0).Err())
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `user.name`, `a`, `b`)
+	r.NoError(err)
+	r.True(ok)
+
+	v, err = client.Get(ctx, jkey).Result()
+	r.NoError(err)
+	r.Equal(`{"id":1234567890123456789,"tags":[],"note":"a.b\\\"}","user":{"name":"b","ids":[9007199254740993]}}`, v)
+
+	//创建不存在的嵌套字段，替换不是对象的中间字段
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `meta.ext.level`, nil, 1)
+	r.NoError(err)
+	r.True(ok)
+
+	ok, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `tags.first`, nil, `x`)
+	r.NoError(err)
+	r.True(ok)
+
+	v, err = client.Get(ctx, jkey).Result()
+	r.NoError(err)
+	r.Equal(`{"id":1234567890123456789,"tags":{"first":"x"},"note":"a.b\\\"}","user":{"name":"b","ids":[9007199254740993]},"meta":{"ext":{"level":1}}}`, v)
+
+	r.NoError(client.Set(ctx, jkey, `[1]`, 0).Err())
+	_, err = rdb.SetJSONFieldEQ(ctx, client, jkey, `a`, nil, 1)
+	r.Error(err)
+}
@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/bingooh/b-go-util/internal/rdbtest"
+	"github.com/bingooh/b-go-util/rdb"
+	"github.com/bingooh/b-go-util/rpc"
+	"github.com/bingooh/b-go-util/test/rpc/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 记录调用次数，name为slow时延迟响应，为fail时返回错误，为panic时崩溃
+type hiIdempotencyServer struct {
+	pb.UnimplementedGreeterServer
+	count int32
+}
+
+func (s *hiIdempotencyServer) Hi(ctx context.Context, req *pb.HiReq) (*pb.HiRsp, error) {
+	n := atomic.AddInt32(&s.count, 1)
+	switch req.Name {
+	case `slow`:
+		time.Sleep(500 * time.Millisecond)
+	case `fail`:
+		return nil, status.Error(codes.Internal, `fail`)
+	case `panic`:
+		panic(`hi panic`)
+	}
+
+	return &pb.HiRsp{Msg: fmt.Sprintf(`hi,%v`, n)}, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	r := require.New(t)
+
+	store := rdb.MustNewIdempotencyStore(&rdb.IdempotencyStoreOption{}, rdbtest.MustNewClient(t))
+	mw := rpc.NewMWIdempotency(store, &rpc.MWIdempotencyOption{Required: true})
+
+	hi := &hiIdempotencyServer{}
+	//恢复崩溃，返回codes.Internal
+	recoverer := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = status.Errorf(codes.Internal, `panic: %v`, r)
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(recoverer, mw.NewUnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(mw.NewStreamServerInterceptor()),
+	)
+	defer server.GracefulStop()
+
+	pb.RegisterGreeterServer(server, hi)
+	rpc.MustStartServer(server, port)
+
+	conn := rpc.MustNewInsecureClientConn(port, 0,
+		grpc.WithChainUnaryInterceptor(mw.NewUnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(mw.NewStreamClientInterceptor()),
+	)
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+
+	doHi := func(key, name string, opts ...grpc.CallOption) (*pb.HiRsp, error) {
+		ctx := context.Background()
+		if key != `` {
+			ctx = rpc.IdempotencyKeyIntoContext(ctx, key)
+		}
+
+		return c.Hi(ctx, &pb.HiReq{Name: name}, opts...)
+	}
+
+	_, err := doHi(``, `bingo`)
+	r.Equal(codes.InvalidArgument, status.Code(err))
+
+	//重复调用重放首次调用的响应
+	rsp, err := doHi(`k1`, `bingo`)
+	r.NoError(err)
+	r.Equal(`hi,1`, rsp.Msg)
+
+	var header metadata.MD
+	rsp, err = doHi(`k1`, `bingo`, grpc.Header(&header))
+	r.NoError(err)
+	r.Equal(`hi,1`, rsp.Msg)
+	v, _ := rpc.GetMDVal(header, `idempotent-replayed`, 0)
+	r.Equal(`true`, v)
+	r.EqualValues(1, atomic.LoadInt32(&hi.count))
+
+	//首次调用处理中
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := doHi(`k2`, `slow`)
+		r.NoError(err)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = doHi(`k2`, `slow`)
+	r.Equal(codes.Aborted, status.Code(err))
+	<-done
+
+	//调用失败允许重试
+	_, err = doHi(`k3`, `fail`)
+	r.Equal(codes.Internal, status.Code(err))
+
+	header = nil
+	rsp, err = doHi(`k3`, `bingo`, grpc.Header(&header))
+	r.NoError(err)
+	r.Equal(`hi,4`, rsp.Msg)
+	r.Empty(header.Get(`idempotent-replayed`))
+
+	//调用崩溃允许重试
+	_, err = doHi(`k4`, `panic`)
+	r.Equal(codes.Internal, status.Code(err))
+
+	rsp, err = doHi(`k4`, `bingo`)
+	r.NoError(err)
+	r.Equal(`hi,6`, rsp.Msg)
+}
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"context"
+	"github.com/bingooh/b-go-util/internal/rdbtest"
+	"github.com/bingooh/b-go-util/rpc"
+	"github.com/bingooh/b-go-util/test/rpc/pb"
+	"github.com/bingooh/b-go-util/util"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	client := rdbtest.MustNewClient(t)
+
+	//读取配置文件conf/ratelimit_grpc.toml
+	mw := rpc.MustNewMWRateLimitFromCfgFile(`ratelimit_grpc`, client)
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(mw.NewUnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(mw.NewStreamServerInterceptor()),
+	)
+	defer server.GracefulStop()
+
+	pb.RegisterGreeterServer(server, &pb.HiAuthServer{})
+	rpc.MustStartServer(server, port)
+
+	conn := rpc.MustNewInsecureClientConn(port, 0)
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+
+	//每秒最多调用2次
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		_, err := c.Hi(ctx, &pb.HiReq{Name: `bingo`}, grpc.Header(&header))
+		r.NoError(err)
+
+		v, ok := rpc.GetMDVal(header, `x-ratelimit-remaining`, 0)
+		r.True(ok)
+		r.EqualValues(strconv.Itoa(1-i), v)
+	}
+
+	_, err := c.Hi(ctx, &pb.HiReq{Name: `bingo`})
+	r.Equal(codes.ResourceExhausted, status.Code(err))
+	r.True(util.HasErrCode(rpc.ToBizErr(err), util.ErrCodeTooOften))
+
+	time.Sleep(1 * time.Second)
+	_, err = c.Hi(ctx, &pb.HiReq{Name: `bingo`})
+	r.NoError(err)
+}
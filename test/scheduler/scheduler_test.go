@@ -1,9 +1,15 @@
 package scheduler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/bingooh/b-go-util/scheduler"
 	"github.com/bingooh/b-go-util/util"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -55,3 +61,155 @@ func TestScheduler(t *testing.T) {
 	s.Stop(0)
 	time.Sleep(10 * time.Second)
 }
+
+func TestSchedulerContextTask(t *testing.T) {
+	r := require.New(t)
+
+	s := scheduler.MustNewScheduler(&scheduler.Option{
+		EnableCronSeconds: true,
+		Tasks: map[string]*scheduler.TaskOption{
+			`timeout`: {Cron: `@every 1s`, SkipIfStillRunning: true, Timeout: 200 * time.Millisecond},
+			`cancel`:  {Cron: `@every 1s`, SkipIfStillRunning: true},
+		},
+	})
+
+	errs := make(chan error, 10)
+	s.MustAddContextTaskFn(`timeout`, func(ctx context.Context) error {
+		<-ctx.Done()
+		errs <- ctx.Err()
+		return ctx.Err()
+	})
+
+	canceled := make(chan error, 1)
+	s.MustAddContextTaskFn(`cancel`, func(ctx context.Context) error {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil
+	})
+
+	s.Start()
+	time.Sleep(1500 * time.Millisecond)
+	r.ErrorIs(<-errs, context.DeadlineExceeded)
+
+	//停止时取消执行中的任务ctx，不必等待超时
+	start := time.Now()
+	s.Stop(5 * time.Second)
+	r.True(time.Since(start) < time.Second)
+	r.ErrorIs(<-canceled, context.Canceled)
+}
+
+func TestSchedulerAdmin(t *testing.T) {
+	r := require.New(t)
+
+	s := scheduler.MustNewScheduler(&scheduler.Option{
+		EnableCronSeconds: true,
+		Tasks: map[string]*scheduler.TaskOption{
+			`hourly`: {Cron: `@every 1h`},
+			`second`: {Cron: `@every 1s`, SkipIfStillRunning: true},
+		},
+	})
+
+	hourly := util.NewAtomicInt64(0)
+	s.MustAddTaskFn(`hourly`, func() {
+		hourly.Incr(1)
+	})
+	s.MustAddContextTaskFn(`second`, func(ctx context.Context) error {
+		return errors.New(`second failed`)
+	})
+
+	router := gin.New()
+	s.RegisterHandlers(router.Group(`/admin`))
+	call := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	s.Start()
+	defer s.Stop(time.Second)
+
+	//立即执行
+	r.Equal(http.StatusNoContent, call(http.MethodPost, `/admin/tasks/hourly/run`).Code)
+	time.Sleep(100 * time.Millisecond)
+	r.EqualValues(1, hourly.Value())
+
+	w := call(http.MethodGet, `/admin/tasks/hourly`)
+	r.Equal(http.StatusOK, w.Code)
+
+	info := &scheduler.TaskInfo{}
+	r.NoError(json.Unmarshal(w.Body.Bytes(), info))
+	r.Equal(`hourly`, info.Name)
+	r.EqualValues(1, info.RunCount)
+	r.False(info.LastRunTime.IsZero())
+	r.True(info.PrevRunTime.IsZero())
+	r.True(info.NextRunTime.After(time.Now().Add(50 * time.Minute)))
+
+	//执行出错
+	time.Sleep(1200 * time.Millisecond)
+	info, err := s.GetTask(`second`)
+	r.NoError(err)
+	r.True(info.ErrorCount > 0)
+	r.Equal(info.RunCount, info.ErrorCount)
+	r.Equal(`second failed`, info.LastError)
+
+	//暂停后跳过定时触发
+	r.Equal(http.StatusNoContent, call(http.MethodPost, `/admin/tasks/second/pause`).Code)
+	info, _ = s.GetTask(`second`)
+	r.True(info.Paused)
+	n := info.RunCount
+	time.Sleep(1500 * time.Millisecond)
+	info, _ = s.GetTask(`second`)
+	r.Equal(n, info.RunCount)
+
+	r.NoError(s.Resume(`second`))
+	time.Sleep(1500 * time.Millisecond)
+	info, _ = s.GetTask(`second`)
+	r.True(info.RunCount > n)
+
+	tasks := s.ListTasks()
+	r.Len(tasks, 2)
+	r.Equal(`hourly`, tasks[0].Name)
+	r.Equal(`second`, tasks[1].Name)
+
+	r.Equal(http.StatusNotFound, call(http.MethodPost, `/admin/tasks/none/run`).Code)
+	r.Error(s.RunNow(`none`))
+}
+
+func TestSchedulerRunNowStop(t *testing.T) {
+	r := require.New(t)
+
+	s := scheduler.MustNewScheduler(&scheduler.Option{
+		Tasks: map[string]*scheduler.TaskOption{
+			`slow`:  {Cron: `@every 1h`},
+			`panic`: {Cron: `@every 1h`},
+		},
+	})
+
+	finished := util.NewAtomicBool(false)
+	s.MustAddContextTaskFn(`slow`, func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(300 * time.Millisecond)
+		finished.Set(true)
+		return nil
+	})
+
+	//未启用EnableRecover，RunNow()执行的任务崩溃不会导致进程退出
+	s.MustAddTaskFn(`panic`, func() {
+		panic(`run now panic`)
+	})
+
+	s.Start()
+	r.NoError(s.RunNow(`panic`))
+	r.NoError(s.RunNow(`slow`))
+	time.Sleep(100 * time.Millisecond)
+
+	//等待RunNow()执行中的任务结束
+	s.Stop(5 * time.Second)
+	r.True(finished.True())
+
+	info, err := s.GetTask(`panic`)
+	r.NoError(err)
+	r.EqualValues(1, info.ErrorCount)
+
+	r.True(util.HasErrCode(s.RunNow(`slow`), util.ErrCodeIllegalState))
+}